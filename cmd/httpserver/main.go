@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"httpserver/internal/headers"
+	"httpserver/internal/middleware"
 	"httpserver/internal/request"
 	"httpserver/internal/response"
+	"httpserver/internal/router"
 	"httpserver/internal/server"
 	"io"
 	"log"
@@ -15,138 +20,126 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
-const port = 42069
+const (
+	port            = 42069
+	shutdownTimeout = 30 * time.Second
+	certPollPeriod  = 30 * time.Second
+)
 
 func main() {
-	server, err := server.Serve(port, handler)
+	certFile := flag.String("cert", "", "TLS certificate file; enables HTTPS together with -key")
+	keyFile := flag.String("key", "", "TLS private key file")
+	clientCAFile := flag.String("client-ca", "", "CA file for verifying TLS client certificates")
+	flag.Parse()
+
+	handler := newRouter().Handler()
+	opts := []server.Option{
+		server.WithAddress("127.0.0.1"),
+		server.WithReadHeaderTimeout(10 * time.Second),
+		server.WithReadTimeout(30 * time.Second),
+		server.WithIdleTimeout(2 * time.Minute),
+		server.WithMaxBodyBytes(10 << 20),
+	}
+	if *clientCAFile != "" {
+		clientCAs, err := server.LoadCertPool(*clientCAFile)
+		if err != nil {
+			log.Fatalf("Error loading client CAs: %v", err)
+		}
+		opts = append(opts, server.WithClientAuth(clientCAs, tls.RequireAndVerifyClientCert))
+	}
+	var srv *server.Server
+	var err error
+	if *certFile != "" || *keyFile != "" {
+		var certs *server.CertStore
+		certs, err = server.NewCertStore(server.CertFile{CertFile: *certFile, KeyFile: *keyFile})
+		if err != nil {
+			log.Fatalf("Error loading certificates: %v", err)
+		}
+		stopWatching := certs.Watch(certPollPeriod)
+		defer stopWatching()
+		srv, err = server.ServeTLS(port, handler, certs, opts...)
+	} else {
+		srv, err = server.Serve(port, handler, opts...)
+	}
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
 
-func handler(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		handlerProxyHTTPBin(w, req)
-		return
-	}
-	switch req.RequestLine.RequestTarget {
-	case "/":
-		defaultHandler(w, req)
-		return
-	case "/yourproblem":
-		handlerYourProblem(w, req)
-		return
-	case "/myproblem":
-		handlerMyProblem(w, req)
-		return
-	case "/video":
-		handlerVideo(w, req)
-		return
-	default:
-		w.Write([]byte("All good, frfr\n"))
-		return
-	}
+func newRouter() *router.Router {
+	r := router.New()
+	r.Use(middleware.RequestID(), middleware.Logger(nil), middleware.Recover(nil, nil), middleware.Timing(), middleware.CanonicalPath())
+	r.Handle("GET", "/", server.HandleErrors(defaultHandler, nil, nil))
+	r.Handle("GET", "/yourproblem", server.HandleErrors(handlerYourProblem, nil, nil))
+	r.Handle("GET", "/myproblem", server.HandleErrors(handlerMyProblem, nil, nil))
+	r.Handle("GET", "/video", server.HandleErrors(handlerVideo, nil, nil))
+	r.Handle("GET", "/httpbin/*path", server.HandleErrors(handlerProxyHTTPBin, nil, nil))
+	r.NotFound = server.HandleErrors(defaultHandler, nil, nil)
+	return r
 }
 
-func handlerMyProblem(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.INTERNAL_SERVER_ERROR)
-	message := []byte(`
-	<html>
-		<head>
-			<title>500 Internal Server Error</title>
-		</head>
-		<body>
-			<h1>Internal Server Error</h1>
-			<p>Okay, you know what? This one is on me.</p>
-		</body>
-	</html>`)
-	headers := response.GetDefaultHeaders(len(message))
-	headers.Overwrite("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	w.WriteBody(message)
+var errMyProblem = &server.HandlerError{
+	Code:    response.INTERNAL_SERVER_ERROR,
+	Message: "Okay, you know what? This one is on me.",
 }
 
-func handlerYourProblem(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.BAD_REQUEST)
-	message := []byte(`
-	<html>
-		<head>
-			<title>400 Bad Request</title>
-		</head>
-		<body>
-			<h1>Bad Request</h1>
-			<p>Your request honestly kinda sucked.</p>
-		</body>
-	</html>`)
-	headers := response.GetDefaultHeaders(len(message))
-	headers.Overwrite("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	w.WriteBody(message)
+func handlerMyProblem(_ *response.Writer, _ *request.Request) error {
+	return errMyProblem
 }
 
-func defaultHandler(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.NOT_FOUND)
-	message := []byte(`
-	<html>
-		<head>
-			<title>404 Not Found</title>
-		</head>
-		<body>
-			<h1>Not Found</h1>
-			<p>The requested resource could not be found.</p>
-		</body>
-	</html>`)
-	headers := response.GetDefaultHeaders(len(message))
-	headers.Overwrite("Content-Type", "text/html")
-	w.WriteHeaders(headers)
-	w.WriteBody(message)
+func handlerYourProblem(_ *response.Writer, _ *request.Request) error {
+	return &server.HandlerError{
+		Code:    response.BAD_REQUEST,
+		Message: "Your request honestly kinda sucked.",
+	}
+}
+
+func defaultHandler(_ *response.Writer, _ *request.Request) error {
+	return &server.HandlerError{
+		Code:    response.NOT_FOUND,
+		Message: "The requested resource could not be found.",
+	}
 }
 
-func handlerProxyHTTPBin(w *response.Writer, req *request.Request) {
+func handlerProxyHTTPBin(w *response.Writer, req *request.Request) error {
 	buf := make([]byte, 1024)
-	stream := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
+	stream := "/" + req.Param("path")
+	if req.URL.RawQuery != "" {
+		stream += "?" + req.URL.RawQuery
+	}
 	resp, err := http.Get("https://httpbin.org" + stream)
 	if err != nil {
-		w.WriteStatusLine(response.INTERNAL_SERVER_ERROR)
-		message := []byte(`
-	<html>
-		<head>
-			<title>500 Internal Server Error</title>
-		</head>
-		<body>
-			<h1>Internal Server Error</h1>
-			<p>Okay, you know what? This one is on me.</p>
-		</body>
-	</html>`)
-		headers := response.GetDefaultHeaders(len(message))
-		headers.Overwrite("Content-Type", "text/html")
-		w.WriteHeaders(headers)
-		w.WriteBody(message)
-		return
+		log.Printf("Error proxying to httpbin: %v", err)
+		return errMyProblem
 	}
 	defer resp.Body.Close()
-	w.WriteStatusLine(response.StatusCode(resp.StatusCode))
-	header := headers.Headers{
-		"Transfer-Encoding": "chunked",
-		"Content-Type":      resp.Header.Get("Content-Type"),
-	}
-	header.Set("Trailer", "X-Content-SHA256")
-	header.Set("Trailer", "X-Content-Length")
+	reason := strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" ")
+	w.WriteStatusLineReason(response.StatusCode(resp.StatusCode), reason)
+	w.DeclareTrailer("X-Content-SHA256", "X-Content-Length")
+	header := headers.NewHeaders()
+	header.Add("Content-Type", resp.Header.Get("Content-Type"))
 	w.WriteHeaders(header)
 	acc := []byte{}
 	for {
 		n, err := resp.Body.Read(buf)
 		if n > 0 {
-			w.WriteChunkedBody(buf[:n])
+			w.Write(buf[:n])
+			w.Flush()
 			acc = append(acc, buf[:n]...)
 		}
 		if err == io.EOF {
@@ -156,39 +149,24 @@ func handlerProxyHTTPBin(w *response.Writer, req *request.Request) {
 			break
 		}
 	}
-	w.WriteChunkedBodyDone()
-	trailerHeaders := headers.NewHeaders()
-	trailerHeaders.Set("X-Content-SHA256", fmt.Sprintf("%x", sha256.Sum256(acc)))
-	trailerHeaders.Set("X-Content-Length", strconv.Itoa(len(acc)))
-	w.WriteTrailers(trailerHeaders)
-	log.Println(trailerHeaders)
+	sum := fmt.Sprintf("%x", sha256.Sum256(acc))
+	w.SetTrailer("X-Content-SHA256", sum)
+	w.SetTrailer("X-Content-Length", strconv.Itoa(len(acc)))
+	log.Printf("Trailers: X-Content-SHA256=%s X-Content-Length=%d", sum, len(acc))
+	return nil
 }
 
-func handlerVideo(w *response.Writer, req *request.Request) {
-	w.WriteStatusLine(response.OK)
-	header := headers.Headers{
-		"Content-Type": "video/mp4",
-	}
+func handlerVideo(w *response.Writer, _ *request.Request) error {
 	vidBuff, err := os.ReadFile("./assets/vim.mp4")
 	if err != nil {
-		w.WriteStatusLine(response.INTERNAL_SERVER_ERROR)
-		message := []byte(`
-	<html>
-		<head>
-			<title>500 Internal Server Error</title>
-		</head>
-		<body>
-			<h1>Internal Server Error</h1>
-			<p>Okay, you know what? This one is on me.</p>
-		</body>
-	</html>`)
-		headers := response.GetDefaultHeaders(len(message))
-		headers.Overwrite("Content-Type", "text/html")
-		w.WriteHeaders(headers)
-		w.WriteBody(message)
-		return
+		log.Printf("Error reading video: %v", err)
+		return errMyProblem
 	}
-	header.Set("Content-Length", strconv.Itoa(len(vidBuff)))
+	header := headers.NewHeaders()
+	header.Add("Content-Type", "video/mp4")
+	header.Add("Content-Length", strconv.Itoa(len(vidBuff)))
+	w.WriteStatusLine(response.OK)
 	w.WriteHeaders(header)
 	w.WriteBody(vidBuff)
+	return nil
 }
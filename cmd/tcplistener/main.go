@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"httpserver/internal/request"
+	"io"
 	"net"
 	"os"
 )
@@ -31,11 +32,15 @@ func main() {
 		fmt.Println("- Target:", request.RequestLine.RequestTarget)
 		fmt.Println("- Version:", request.RequestLine.HttpVersion)
 		fmt.Println("Headers:")
-		for key, value := range request.Headers {
+		for key, value := range request.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, value)
 		}
 		fmt.Println("Body:")
-		fmt.Println(string(request.Body))
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+		fmt.Println(string(body))
 		fmt.Println("Connection Closed.")
 	}
 }
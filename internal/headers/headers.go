@@ -1,19 +1,38 @@
 package headers
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"iter"
 	"slices"
 	"strings"
 )
 
-type Headers map[string]string
+// Headers is an ordered list of header fields. Names keep the case they
+// were added or received with and are matched case-insensitively. The zero
+// value is empty and ready to use.
+type Headers struct {
+	fields []field
+}
 
-func NewHeaders() Headers {
-	return make(Headers)
+type field struct {
+	name, value string
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	idx := strings.Index(string(data), "\r\n")
+var (
+	ErrMalformedHeader = errors.New("malformed header")
+	// ErrInvalidField is returned by Validate for fields that cannot be
+	// written without corrupting the message.
+	ErrInvalidField = errors.New("invalid header field")
+)
+
+func NewHeaders() *Headers {
+	return &Headers{}
+}
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	idx := bytes.Index(data, crlf)
 	if idx == -1 {
 		return 0, false, nil
 	}
@@ -21,50 +40,191 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 2, true, nil
 	}
 
-	headerline := string(data)[:idx]
-	header := strings.SplitN(headerline, ":", 2)
-	if len(header) != 2 {
-		return 0, false, errors.New("malformed header")
+	line := data[:idx]
+	if bytes.IndexByte(line, '\r') != -1 || bytes.IndexByte(line, '\n') != -1 {
+		return 0, false, fmt.Errorf("%w: bare CR or LF", ErrMalformedHeader)
+	}
+	colon := bytes.IndexByte(line, ':')
+	if colon == -1 {
+		return 0, false, fmt.Errorf("%w: missing colon", ErrMalformedHeader)
+	}
+	name := line[:colon]
+	if len(name) == 0 {
+		return 0, false, fmt.Errorf("%w: empty name", ErrMalformedHeader)
 	}
-	if header[0][len(header[0])-1:] == " " {
-		return 0, false, errors.New("malformed header")
+	if last := name[len(name)-1]; last == ' ' || last == '\t' {
+		return 0, false, fmt.Errorf("%w: whitespace before colon", ErrMalformedHeader)
 	}
-	key, value := strings.TrimSpace(header[0]), strings.TrimSpace(header[1])
-	if !validToken([]byte(key)) {
-		return 0, false, errors.New("malformed header")
+	name = bytes.TrimLeft(name, " \t")
+	if !validToken(name) {
+		return 0, false, fmt.Errorf("%w: invalid name %q", ErrMalformedHeader, name)
+	}
+	value := bytes.Trim(line[colon+1:], " \t")
+	if !validValue(value) {
+		return 0, false, fmt.Errorf("%w: invalid character in value of %s", ErrMalformedHeader, name)
 	}
 
-	key = strings.ToLower(key)
-	h.Set(key, value)
+	h.Add(internName(name), string(value))
 
 	return idx + 2, false, nil
 }
 
+var crlf = []byte("\r\n")
+
+// commonNames lets internName return the usual request header names,
+// spelled the way curl or browsers send them, without allocating.
+var commonNames = map[string]string{}
+
+func init() {
+	for _, name := range []string{
+		"Accept", "Accept-Encoding", "Accept-Language", "Authorization",
+		"Cache-Control", "Connection", "Content-Length", "Content-Type",
+		"Cookie", "DNT", "Expect", "Host", "If-Modified-Since",
+		"If-None-Match", "Origin", "Pragma", "Priority", "Referer",
+		"Sec-Ch-Ua", "Sec-Ch-Ua-Mobile", "Sec-Ch-Ua-Platform",
+		"Sec-Fetch-Dest", "Sec-Fetch-Mode", "Sec-Fetch-Site", "Sec-Fetch-User",
+		"TE", "Trailer", "Transfer-Encoding", "Upgrade",
+		"Upgrade-Insecure-Requests", "User-Agent", "X-Forwarded-For",
+		"X-Request-Id",
+	} {
+		lower := strings.ToLower(name)
+		commonNames[name] = name
+		commonNames[lower] = lower
+	}
+}
+
+func internName(name []byte) string {
+	if s, ok := commonNames[string(name)]; ok {
+		return s
+	}
+	return string(name)
+}
+
 var tokenChars = []byte{'!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~'}
 
-func (h Headers) Set(key, value string) {
-	key = strings.ToLower(key)
-	v, ok := h[key]
-	if ok {
-		value = strings.Join([]string{v, value}, ", ")
+// Add appends a field, keeping any existing fields with the same name.
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, field{key, value})
+}
+
+// Set adds value to the field named key, joined with ", " to what is
+// already there, or adds the field if there is none. Use Add for fields
+// such as Set-Cookie that must not be combined.
+func (h *Headers) Set(key, value string) {
+	for i := range h.fields {
+		if strings.EqualFold(h.fields[i].name, key) {
+			h.fields[i].value += ", " + value
+			return
+		}
+	}
+	h.Add(key, value)
+}
+
+// Overwrite replaces every field named key, in any case, with a single one.
+func (h *Headers) Overwrite(key, value string) {
+	h.Del(key)
+	h.Add(key, value)
+}
+
+// Del removes every field named key.
+func (h *Headers) Del(key string) {
+	h.fields = slices.DeleteFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
+}
+
+// Get returns the values of all fields named key joined with ", ", and
+// whether there was any.
+func (h *Headers) Get(key string) (string, bool) {
+	value, found := "", false
+	for _, f := range h.fields {
+		if !strings.EqualFold(f.name, key) {
+			continue
+		}
+		if found {
+			value += ", " + f.value
+		} else {
+			value, found = f.value, true
+		}
+	}
+	return value, found
+}
+
+// Values returns the value of every field named key in order.
+func (h *Headers) Values(key string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Len returns the number of fields.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// All iterates over the fields in order with their names as added.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
 	}
-	h[key] = value
 }
 
-func (h Headers) Overwrite(key, value string) {
-	key = strings.ToLower(key)
-	h[key] = value
+func (h *Headers) HasToken(key, token string) bool {
+	v, ok := h.Get(key)
+	if !ok {
+		return false
+	}
+	for _, t := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	key = strings.ToLower(key)
-	v, ok := h[key]
-	return v, ok
+// Validate checks every field against RFC 9110 section 5: names must be
+// tokens and values must not contain control characters other than tab,
+// which rules out the CR and LF of a header injection.
+func (h *Headers) Validate() error {
+	for _, f := range h.fields {
+		if !ValidName(f.name) {
+			return fmt.Errorf("%w: name %q", ErrInvalidField, f.name)
+		}
+		if !ValidValue(f.value) {
+			return fmt.Errorf("%w: value of %s %q", ErrInvalidField, f.name, f.value)
+		}
+	}
+	return nil
+}
+
+func ValidName(name string) bool {
+	return name != "" && validToken(name)
+}
+
+func ValidValue(value string) bool {
+	return validValue(value)
+}
+
+func validToken[T string | []byte](b T) bool {
+	for i := 0; i < len(b); i++ {
+		if !isTokenChar(b[i]) {
+			return false
+		}
+	}
+	return true
 }
 
-func validToken(b []byte) bool {
-	for _, c := range b {
-		if !isTokenChar(c) {
+func validValue[T string | []byte](v T) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < ' ' && c != '\t' || c == 0x7f {
 			return false
 		}
 	}
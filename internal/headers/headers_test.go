@@ -14,7 +14,7 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -24,18 +24,19 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, 57, n)
 	assert.False(t, done)
 
 	// Test: Valid 2 headers with existing headers
-	headers = map[string]string{"host": "localhost:42069"}
+	headers = NewHeaders()
+	headers.Add("Host", "localhost:42069")
 	data = []byte("User-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "curl/7.81.0", headers["user-agent"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
+	assert.Equal(t, "curl/7.81.0", get(headers, "user-agent"))
 	assert.Equal(t, 25, n)
 	assert.False(t, done)
 
@@ -45,7 +46,7 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Empty(t, headers)
+	assert.Equal(t, 0, headers.Len())
 	assert.Equal(t, 2, n)
 	assert.True(t, done)
 
@@ -65,3 +66,87 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func get(h *Headers, key string) string {
+	v, _ := h.Get(key)
+	return v
+}
+
+func TestHeadersOrder(t *testing.T) {
+	h := NewHeaders()
+	for _, line := range []string{
+		"Host: localhost:42069\r\n",
+		"Accept: text/html\r\n",
+		"X-Custom-Thing: one\r\n",
+		"accept: application/json\r\n",
+	} {
+		_, _, err := h.Parse([]byte(line))
+		require.NoError(t, err)
+	}
+
+	// Test: Repeated fields are kept apart and joined by Get
+	assert.Equal(t, []string{"text/html", "application/json"}, h.Values("ACCEPT"))
+	assert.Equal(t, "text/html, application/json", get(h, "Accept"))
+	assert.Equal(t, 4, h.Len())
+
+	// Test: Fields iterate in order with their original case
+	var names []string
+	for name := range h.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Host", "Accept", "X-Custom-Thing", "accept"}, names)
+
+	// Test: Add keeps Set-Cookie fields separate
+	h = NewHeaders()
+	h.Add("Set-Cookie", "a=1")
+	h.Add("Set-Cookie", "b=2; Path=/")
+	assert.Equal(t, []string{"a=1", "b=2; Path=/"}, h.Values("set-cookie"))
+
+	// Test: Set joins, Overwrite replaces, Del removes
+	h.Set("Vary", "Accept")
+	h.Set("vary", "Origin")
+	assert.Equal(t, []string{"Accept, Origin"}, h.Values("Vary"))
+	h.Overwrite("set-cookie", "c=3")
+	assert.Equal(t, []string{"c=3"}, h.Values("Set-Cookie"))
+	h.Del("VARY")
+	_, ok := h.Get("Vary")
+	assert.False(t, ok)
+	assert.Equal(t, 1, h.Len())
+
+	// Test: Zero value is usable
+	var zero Headers
+	zero.Add("Host", "example.com")
+	assert.Equal(t, "example.com", get(&zero, "host"))
+}
+
+func TestHeadersValidation(t *testing.T) {
+	for _, line := range []string{
+		"X-Evil: a\x00b\r\n",
+		"X-Evil: a\x1bb\r\n",
+		"X-Evil: a\x7fb\r\n",
+		"X-Evil: a\x0bb\r\n",
+	} {
+		// Test: Control characters in received values are rejected
+		_, _, err := NewHeaders().Parse([]byte(line))
+		assert.ErrorIs(t, err, ErrMalformedHeader, "%q", line)
+	}
+
+	// Test: Tabs and obs-text are accepted in values
+	h := NewHeaders()
+	_, _, err := h.Parse([]byte("X-Note: caf\xc3\xa9\tau lait\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "caf\xc3\xa9\tau lait", get(h, "X-Note"))
+
+	// Test: Validate reports the first unsafe field
+	h = NewHeaders()
+	h.Add("Content-Type", "text/plain")
+	require.NoError(t, h.Validate())
+	h.Add("Location", "/next\r\nSet-Cookie: session=stolen")
+	assert.ErrorIs(t, h.Validate(), ErrInvalidField)
+	h.Del("Location")
+	h.Add("Bad Name", "x")
+	assert.ErrorIs(t, h.Validate(), ErrInvalidField)
+	h.Del("Bad Name")
+	h.Add("", "x")
+	assert.ErrorIs(t, h.Validate(), ErrInvalidField)
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"httpserver/internal/headers"
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"httpserver/internal/server"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-Id"
+
+// Logger logs method, target, status, body size and duration of every
+// request to l, or to the standard logger when l is nil.
+func Logger(l *log.Logger) server.Middleware {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			id, _ := req.Headers.Get(RequestIDHeader)
+			l.Printf("%s %s %d %d %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget, w.Status(), w.BytesWritten(), time.Since(start), id)
+		}
+	}
+}
+
+// Recover turns a panic in the wrapped handler into a 500 response rendered
+// with render, or with server.RenderError when render is nil, and logs the
+// stack trace to l, or to the standard logger when l is nil. When the
+// response has already been partly sent it panics again so that the server
+// aborts the connection.
+func Recover(l *log.Logger, render server.ErrorRenderer) server.Middleware {
+	if l == nil {
+		l = log.Default()
+	}
+	if render == nil {
+		render = server.RenderError
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			defer func() {
+				if v := recover(); v != nil {
+					if w.Committed() {
+						panic(v)
+					}
+					l.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+					render(w, req, response.INTERNAL_SERVER_ERROR, "")
+				}
+			}()
+			next(w, req)
+		}
+	}
+}
+
+// RequestID makes sure every request carries an X-Request-Id header, keeping
+// a well-formed one sent by the client, and echoes it on the response.
+func RequestID() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, ok := req.Headers.Get(RequestIDHeader)
+			if !ok || !validRequestID(id) {
+				id = newRequestID()
+				req.Headers.Overwrite(RequestIDHeader, id)
+			}
+			w.Header().Overwrite(RequestIDHeader, id)
+			next(w, req)
+		}
+	}
+}
+
+// Timing adds a Server-Timing header with the time the handler took until
+// it wrote its response headers.
+func Timing() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			w.BeforeHeaders(func(h *headers.Headers) {
+				ms := float64(time.Since(start).Microseconds()) / 1000
+				h.Set("Server-Timing", "app;dur="+strconv.FormatFloat(ms, 'f', 3, 64))
+			})
+			next(w, req)
+		}
+	}
+}
+
+// CanonicalPath redirects GET and HEAD requests whose path was not sent in
+// canonical form, for example "/static/./css//site.css" or "/caf%c3%a9", to
+// the canonical path with 301 Moved Permanently. Other methods are served
+// as they are, their URL.Path is clean either way.
+func CanonicalPath() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			method := req.RequestLine.Method
+			if req.URL == nil || method != "GET" && method != "HEAD" {
+				next(w, req)
+				return
+			}
+			canonical := request.EscapePath(req.URL.Path)
+			if canonical == req.URL.RawPath {
+				next(w, req)
+				return
+			}
+			location := canonical
+			if req.URL.RawQuery != "" {
+				location += "?" + req.URL.RawQuery
+			}
+			h := response.GetDefaultHeaders(0)
+			h.Set("Location", location)
+			w.WriteStatusLine(response.MOVED_PERMANENTLY)
+			w.WriteHeaders(h)
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c <= ' ' || c >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"bytes"
+	"httpserver/internal/headers"
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"httpserver/internal/server"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(h map[string]string) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/things", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	for key, value := range h {
+		req.Headers.Set(key, value)
+	}
+	return req
+}
+
+func ok(w *response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+}
+
+func TestChainOrder(t *testing.T) {
+	// Test: First middleware runs outermost
+	order := []string{}
+	mark := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name+">")
+				next(w, req)
+				order = append(order, "<"+name)
+			}
+		}
+	}
+	h := server.Chain(func(*response.Writer, *request.Request) { order = append(order, "handler") }, mark("a"), mark("b"))
+	h(response.NewWriter(&bytes.Buffer{}), newRequest(nil))
+	assert.Equal(t, []string{"a>", "b>", "handler", "<b", "<a"}, order)
+}
+
+func TestLogger(t *testing.T) {
+	// Test: Request is logged after the handler
+	logs := &bytes.Buffer{}
+	h := Logger(log.New(logs, "", 0))(ok)
+	h(response.NewWriter(&bytes.Buffer{}), newRequest(nil))
+	assert.True(t, strings.HasPrefix(logs.String(), "GET /things 200 2 "))
+}
+
+func TestRecover(t *testing.T) {
+	// Test: Panic becomes a 500
+	logs := &bytes.Buffer{}
+	out := &bytes.Buffer{}
+	h := Recover(log.New(logs, "", 0), nil)(func(*response.Writer, *request.Request) { panic("boom") })
+	require.NotPanics(t, func() { h(response.NewWriter(out), newRequest(nil)) })
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Contains(t, logs.String(), "panic serving GET /things: boom")
+
+	// Test: Panic is rendered with the given renderer
+	out = &bytes.Buffer{}
+	h = Recover(log.New(logs, "", 0), server.RenderJSON)(func(*response.Writer, *request.Request) { panic("boom") })
+	h(response.NewWriter(out), newRequest(nil))
+	assert.Contains(t, out.String(), "Content-Type: application/json\r\n")
+
+	// Test: Panic after the response started is passed on to the server
+	h = Recover(log.New(logs, "", 0), nil)(func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OK)
+		panic("late")
+	})
+	assert.PanicsWithValue(t, "late", func() { h(response.NewWriter(&bytes.Buffer{}), newRequest(nil)) })
+}
+
+func TestRequestID(t *testing.T) {
+	// Test: Missing ID is generated and echoed
+	out := &bytes.Buffer{}
+	req := newRequest(nil)
+	RequestID()(ok)(response.NewWriter(out), req)
+	id, found := req.Headers.Get(RequestIDHeader)
+	require.True(t, found)
+	assert.Len(t, id, 16)
+	assert.Contains(t, out.String(), "X-Request-Id: "+id+"\r\n")
+
+	// Test: Client ID is kept
+	out = &bytes.Buffer{}
+	req = newRequest(map[string]string{"X-Request-ID": "abc-123"})
+	RequestID()(ok)(response.NewWriter(out), req)
+	assert.Contains(t, out.String(), "X-Request-Id: abc-123\r\n")
+
+	// Test: Malformed client ID is replaced
+	req = newRequest(map[string]string{"X-Request-ID": strings.Repeat("x", 200)})
+	RequestID()(ok)(response.NewWriter(&bytes.Buffer{}), req)
+	id, _ = req.Headers.Get(RequestIDHeader)
+	assert.Len(t, id, 16)
+}
+
+func TestTiming(t *testing.T) {
+	// Test: Server-Timing header is added
+	out := &bytes.Buffer{}
+	Timing()(ok)(response.NewWriter(out), newRequest(nil))
+	assert.Regexp(t, `Server-Timing: app;dur=\d+\.\d{3}\r\n`, out.String())
+}
+
+func TestCanonicalPath(t *testing.T) {
+	serve := func(method, target string) string {
+		req := newRequest(nil)
+		req.RequestLine.Method = method
+		req.RequestLine.RequestTarget = target
+		u, err := request.ParseTarget(method, target)
+		require.NoError(t, err)
+		req.URL = u
+		out := &bytes.Buffer{}
+		w := response.NewWriter(out)
+		CanonicalPath()(ok)(w, req)
+		require.NoError(t, w.Finish())
+		return out.String()
+	}
+
+	// Test: Canonical paths are served
+	assert.True(t, strings.HasSuffix(serve("GET", "/static/css/site.css?v=3"), "ok"))
+	assert.True(t, strings.HasSuffix(serve("GET", "/caf%C3%A9"), "ok"))
+
+	// Test: Other spellings are redirected with the query kept
+	res := serve("GET", "/static/./css//site.css?v=3")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, res, "Location: /static/css/site.css?v=3\r\n")
+	assert.Contains(t, serve("HEAD", "/caf%c3%a9"), "Location: /caf%C3%A9\r\n")
+	assert.Contains(t, serve("GET", "/static/%2e%2e/%2e%2e/etc/passwd"), "Location: /etc/passwd\r\n")
+
+	// Test: Other methods are not redirected
+	assert.True(t, strings.HasSuffix(serve("POST", "/static/./upload"), "ok"))
+}
@@ -0,0 +1,53 @@
+package request
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+const curlRequest = "GET /coffee HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: curl/7.81.0\r\n" +
+	"Accept: */*\r\n" +
+	"\r\n"
+
+const browserRequest = "GET /static/css/site.css?v=3 HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"Connection: keep-alive\r\n" +
+	"sec-ch-ua: \"Chromium\";v=\"124\", \"Google Chrome\";v=\"124\", \"Not-A.Brand\";v=\"99\"\r\n" +
+	"sec-ch-ua-mobile: ?0\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36\r\n" +
+	"sec-ch-ua-platform: \"Linux\"\r\n" +
+	"Accept: text/css,*/*;q=0.1\r\n" +
+	"Sec-Fetch-Site: same-origin\r\n" +
+	"Sec-Fetch-Mode: no-cors\r\n" +
+	"Sec-Fetch-Dest: style\r\n" +
+	"Referer: http://localhost:42069/\r\n" +
+	"Accept-Encoding: gzip, deflate, br, zstd\r\n" +
+	"Accept-Language: en-US,en;q=0.9\r\n" +
+	"Cookie: session=3f2a9c1e7b; theme=dark\r\n" +
+	"If-None-Match: \"5e8f-1a2b3c\"\r\n" +
+	"\r\n"
+
+func benchmarkRequest(b *testing.B, raw string) {
+	src := strings.NewReader(raw)
+	br := bufio.NewReader(src)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for i := 0; i < b.N; i++ {
+		src.Reset(raw)
+		br.Reset(src)
+		if _, err := RequestFromReader(br); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRequestFromReaderCurl(b *testing.B) {
+	benchmarkRequest(b, curlRequest)
+}
+
+func BenchmarkRequestFromReaderBrowser(b *testing.B) {
+	benchmarkRequest(b, browserRequest)
+}
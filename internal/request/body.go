@@ -0,0 +1,238 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"httpserver/internal/headers"
+	"io"
+)
+
+var (
+	// ErrBodyClosed is returned when reading a body after Close.
+	ErrBodyClosed = errors.New("read on closed body")
+	ErrBadChunk   = errors.New("invalid chunked encoding")
+
+	errAwaitingContinue = errors.New("client is waiting for 100 Continue")
+)
+
+// body reads exactly the declared Content-Length from the connection, or
+// decodes a chunked body up to and including its trailers.
+type body struct {
+	r         *bufio.Reader
+	remaining int64
+	chunked   bool
+	// Chunked bodies only: whether a chunk was read and still needs its
+	// CRLF, the bytes read so far and where the trailers go.
+	inChunk bool
+	read    int64
+	req     *Request
+	closed  bool
+	err     error
+	// awaitingContinue is set while the client waits for 100 Continue,
+	// which sendContinue writes on the first Read.
+	awaitingContinue bool
+	sendContinue     func() error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	if b.awaitingContinue {
+		b.awaitingContinue = false
+		if b.sendContinue != nil {
+			if err := b.sendContinue(); err != nil {
+				b.err = err
+				return 0, err
+			}
+		}
+	}
+	return b.next(p)
+}
+
+func (b *body) next(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.chunked && b.remaining == 0 {
+		if err := b.nextChunk(); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if err == io.EOF && b.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	if err == nil && b.remaining == 0 && !b.chunked {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// nextChunk reads up to the data of the next chunk. After the zero chunk it
+// reads the trailers and leaves remaining at zero with err set to io.EOF.
+func (b *body) nextChunk() error {
+	if b.inChunk {
+		line, err := b.line()
+		if err != nil {
+			return err
+		}
+		if len(line) != 0 {
+			return fmt.Errorf("%w: missing CRLF after chunk data", ErrBadChunk)
+		}
+	}
+	line, err := b.line()
+	if err != nil {
+		return err
+	}
+	size, err := parseChunkSize(line)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		if err := b.readTrailers(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	b.read += size
+	if limit := b.req.limits.MaxBodyBytes; limit > 0 && b.read > int64(limit) {
+		return ErrBodyTooLarge
+	}
+	b.remaining = size
+	b.inChunk = true
+	return nil
+}
+
+// line returns the next line without its CRLF.
+func (b *body) line() ([]byte, error) {
+	line, err := b.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w: line too long", ErrBadChunk)
+	}
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) == 0 || line[len(line)-1] != '\r' {
+		return nil, fmt.Errorf("%w: line not terminated by CRLF", ErrBadChunk)
+	}
+	line = line[:len(line)-1]
+	if bytes.IndexByte(line, '\r') >= 0 {
+		return nil, fmt.Errorf("%w: bare CR", ErrBadChunk)
+	}
+	return line, nil
+}
+
+func (b *body) readTrailers() error {
+	trailers := headers.NewHeaders()
+	size := 0
+	for {
+		line, err := b.line()
+		if err != nil {
+			return err
+		}
+		size += len(line) + 2
+		if limit := b.req.limits.MaxHeaderBytes; limit > 0 && size > limit {
+			return ErrHeaderTooLarge
+		}
+		_, done, err := trailers.Parse([]byte(string(line) + "\r\n"))
+		if err != nil {
+			return err
+		}
+		if done {
+			b.req.Trailers = trailers
+			return nil
+		}
+	}
+}
+
+// parseChunkSize parses the hex size of a chunk-size line, ignoring any
+// chunk extensions.
+func parseChunkSize(line []byte) (int64, error) {
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 || len(line) > 15 {
+		return 0, fmt.Errorf("%w: invalid chunk size %q", ErrBadChunk, line)
+	}
+	var size int64
+	for _, c := range line {
+		var d byte
+		switch {
+		case '0' <= c && c <= '9':
+			d = c - '0'
+		case 'a' <= c && c <= 'f':
+			d = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			return 0, fmt.Errorf("%w: invalid chunk size %q", ErrBadChunk, line)
+		}
+		size = size<<4 | int64(d)
+	}
+	return size, nil
+}
+
+// Close stops the handler from reading further. Unread bytes stay on the
+// connection until DiscardBody consumes them.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+// BodyRemaining returns how many bytes of the body are known to be unread:
+// the rest of a Content-Length body, or of the current chunk.
+func (r *Request) BodyRemaining() int64 {
+	if r.body == nil {
+		return 0
+	}
+	return r.body.remaining
+}
+
+// DiscardBody reads and drops what the handler left of the body, at most
+// max bytes, so the connection can carry the next request. It returns an
+// error when the body could not be consumed completely.
+func (r *Request) DiscardBody(max int64) error {
+	if r.body == nil {
+		return nil
+	}
+	if r.body.awaitingContinue {
+		return errAwaitingContinue
+	}
+	if r.body.remaining > max {
+		return ErrBodyTooLarge
+	}
+	buf := make([]byte, 4096)
+	var discarded int64
+	for {
+		n, err := r.body.next(buf)
+		discarded += int64(n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if discarded > max {
+			return ErrBodyTooLarge
+		}
+	}
+}
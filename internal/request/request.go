@@ -1,23 +1,57 @@
 package request
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"httpserver/internal/headers"
 	"io"
-	"log"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Body        []byte
-	Headers     headers.Headers
+	URL         *URL
+	Body        io.ReadCloser
+	Headers     *headers.Headers
+	Trailers    *headers.Headers
+	TLS         *TLSInfo
+	Params      map[string]string
 	state       requestState
+	limits      Limits
+	body        *body
 }
 
+// Limits bounds how much of a request is read. Zero values mean no limit.
+type Limits struct {
+	MaxHeaderBytes int
+	MaxBodyBytes   int
+}
+
+// Errors returned by RequestFromReader, possibly wrapped with details.
+var (
+	ErrBadRequestLine               = errors.New("invalid request line")
+	ErrBadMethod                    = errors.New("invalid method")
+	ErrMethodNotImplemented         = errors.New("method not implemented")
+	ErrUnsupportedVersion           = errors.New("unsupported HTTP version")
+	ErrMalformedHeader              = headers.ErrMalformedHeader
+	ErrBadContentLength             = errors.New("invalid content length")
+	ErrBadTransferEncoding          = errors.New("invalid transfer encoding")
+	ErrConflictingFraming           = errors.New("both Transfer-Encoding and Content-Length present")
+	ErrTransferCodingNotImplemented = errors.New("transfer coding not implemented")
+	ErrHeaderTooLarge               = errors.New("header section too large")
+	ErrURITooLong                   = errors.New("request line too long")
+	ErrBodyTooLarge                 = errors.New("body too large")
+	ErrExpectationFailed            = errors.New("unsupported expectation")
+	ErrBadHost                      = errors.New("missing or repeated Host")
+)
+
+var crlf = []byte("\r\n")
+
+var knownMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"}
+
 type requestState int
 
 const (
@@ -33,83 +67,132 @@ type RequestLine struct {
 	Method        string
 }
 
+// RequestFromReader reads the request line and headers from reader and
+// returns without reading the body. Body streams it from reader instead and
+// has to be consumed before the next request can be read from reader.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	bufferSize := 8
+	return RequestFromReaderWithLimits(reader, Limits{})
+}
+
+func RequestFromReaderWithLimits(reader io.Reader, limits Limits) (*Request, error) {
+	br, ok := reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(reader)
+	}
 	request := &Request{
-		state:   requestStateInitialized,
-		Headers: headers.NewHeaders(),
+		state:    requestStateInitialized,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		limits:   limits,
 	}
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
-	for {
-		if request.state == requestStateDone {
-			break
+	// Lines are parsed straight out of br's buffer. Only a line longer than
+	// the buffer is copied together in long.
+	var long []byte
+	headerBytes := 0
+	for request.state != requestStateDone {
+		line, err := br.ReadSlice('\n')
+		headerBytes += len(line)
+		if limits.MaxHeaderBytes > 0 && headerBytes > limits.MaxHeaderBytes {
+			if request.state == requestStateInitialized {
+				return nil, ErrURITooLong
+			}
+			return nil, ErrHeaderTooLarge
+		}
+		if err == bufio.ErrBufferFull {
+			long = append(long, line...)
+			continue
 		}
-		if readToIndex >= len(buf) {
-			bufferSize *= 2
-			newBuf := make([]byte, bufferSize)
-			copy(newBuf, buf[:readToIndex])
-			buf = newBuf
+		if long != nil {
+			line = append(long, line...)
+			long = nil
 		}
-		n, err := reader.Read(buf[readToIndex:])
 		if err != nil {
 			if err == io.EOF {
-				if request.state == requestStateDone {
-					break
+				if headerBytes == 0 {
+					return nil, io.EOF
 				}
-				return nil, errors.New("unexpected EOF")
+				return nil, io.ErrUnexpectedEOF
 			}
 			return nil, err
 		}
-		readToIndex += n
-		bConsumed, err := request.parse(buf[:readToIndex])
-		if err != nil {
-			return nil, err
+		if len(line) < 2 || line[len(line)-2] != '\r' {
+			if request.state == requestStateInitialized {
+				return nil, fmt.Errorf("%w: bare LF", ErrBadRequestLine)
+			}
+			return nil, fmt.Errorf("%w: bare LF", ErrMalformedHeader)
 		}
-		if bConsumed == 0 {
+		// RFC 9112 section 2.2: empty lines before the request-line, such
+		// as a CRLF a client sent after the previous body, are ignored.
+		if request.state == requestStateInitialized && len(line) == 2 {
 			continue
 		}
-		copy(buf, buf[bConsumed:readToIndex])
-		readToIndex -= bConsumed
+		if _, err := request.parse(line); err != nil {
+			return nil, err
+		}
 	}
 
+	request.body.r = br
+	request.body.req = request
+	request.Body = request.body
 	return request, nil
 }
 
-func parseRequestLine(line []byte) (RequestLine, int, error) {
-	if !strings.Contains(string(line), "\r\n") {
+// Param returns the path parameter name captured by the router, or "".
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+func parseRequestLine(data []byte) (RequestLine, int, error) {
+	idx := bytes.Index(data, crlf)
+	if idx == -1 {
 		return RequestLine{}, 0, nil
 	}
-
-	request := strings.SplitN(string(line), "\r\n", 2)
-	requestLine := strings.Split(string(request[0]), " ")
-
-	if len(requestLine) != 3 {
-		return RequestLine{}, 0, errors.New("invalid request line")
+	line := data[:idx]
+	if bytes.IndexByte(line, '\r') != -1 || bytes.IndexByte(line, '\n') != -1 {
+		return RequestLine{}, 0, fmt.Errorf("%w: bare CR or LF", ErrBadRequestLine)
+	}
+	method, rest, ok := bytes.Cut(line, []byte(" "))
+	target, version, ok2 := bytes.Cut(rest, []byte(" "))
+	if !ok || !ok2 || bytes.IndexByte(version, ' ') != -1 {
+		return RequestLine{}, 0, fmt.Errorf("%w: %q", ErrBadRequestLine, line)
 	}
 
-	bytesConsumed := len(request[0]) + 2
+	if !isAlpha(method) {
+		return RequestLine{}, 0, fmt.Errorf("%w: %q", ErrBadMethod, method)
+	}
+	known := ""
+	for _, m := range knownMethods {
+		if string(method) == m {
+			known = m
+			break
+		}
+	}
+	if known == "" {
+		return RequestLine{}, 0, fmt.Errorf("%w: %s", ErrMethodNotImplemented, method)
+	}
 
-	hasAlpha, err := regexp.MatchString(`^[a-zA-Z]+$`, requestLine[0])
+	httpVersion, err := parseVersion(version)
 	if err != nil {
 		return RequestLine{}, 0, err
 	}
 
-	if !hasAlpha {
-		return RequestLine{}, 0, errors.New("invalid method")
-	}
-
-	HTTPVersion := strings.Split(requestLine[2], "/")
+	return RequestLine{
+		HttpVersion:   httpVersion,
+		RequestTarget: string(target),
+		Method:        known,
+	}, idx + 2, nil
+}
 
-	if HTTPVersion[0] != "HTTP" || HTTPVersion[1] != "1.1" {
-		return RequestLine{}, 0, errors.New("invalid HTTP version")
+func isAlpha(b []byte) bool {
+	if len(b) == 0 {
+		return false
 	}
-
-	return RequestLine{
-		HttpVersion:   HTTPVersion[1],
-		RequestTarget: requestLine[1],
-		Method:        requestLine[0],
-	}, bytesConsumed, nil
+	for _, c := range b {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -137,11 +220,19 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if bytesConsumed == 0 {
 			return 0, nil
 		}
+		u, err := ParseTarget(requestLine.Method, requestLine.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = requestLine
+		r.URL = u
 		r.state = requestStateParsingHeaders
 		return bytesConsumed, nil
 
 	case requestStateParsingHeaders:
+		if len(data) > 0 && (data[0] == ' ' || data[0] == '\t') {
+			return 0, fmt.Errorf("%w: obsolete line folding", ErrMalformedHeader)
+		}
 		bytesConsumed, complete, err := r.Headers.Parse(data)
 		if err != nil {
 			return 0, err
@@ -155,26 +246,21 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return bytesConsumed, nil
 
 	case requestStateParsingBody:
-		dataLen, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			r.state = requestStateDone
-			return 0, nil
-		}
-		dataLenNum, err := strconv.Atoi(dataLen)
-		if err != nil {
-			return 0, err
+		// RFC 9112 section 3.2: HTTP/1.1 requests carry exactly one Host,
+		// HTTP/1.0 requests at most one.
+		hosts := r.Headers.Values("Host")
+		if len(hosts) > 1 || len(hosts) == 0 && r.RequestLine.HttpVersion != "1.0" {
+			return 0, fmt.Errorf("%w: %d Host fields", ErrBadHost, len(hosts))
 		}
-		if len(r.Body) == dataLenNum {
-			r.state = requestStateDone
-			return 0, nil
+		if r.URL.Host == "" && len(hosts) == 1 {
+			r.URL.Host = hosts[0]
 		}
-		bytesConsumed := len(data)
-		if bytesConsumed+len(r.Body) > dataLenNum {
-			log.Printf("Data Overflow; Data Expected: %v Received: %v: %s", dataLenNum, bytesConsumed+len(r.Body), data)
-			return 0, errors.New("invalid content length")
+		r.body = &body{}
+		r.state = requestStateDone
+		if err := r.parseFraming(); err != nil {
+			return 0, err
 		}
-		r.Body = append(r.Body, data...)
-		return bytesConsumed, nil
+		return 0, r.parseExpect()
 
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
@@ -183,3 +269,115 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return 0, errors.New("invalid request state")
 	}
 }
+
+// parseVersion accepts HTTP/1.0 and HTTP/1.1. Later 1.x versions are
+// served as 1.1, as RFC 9112 section 2.3 asks. Everything else, other major
+// versions included, is unsupported.
+func parseVersion(v []byte) (string, error) {
+	if len(v) != 8 || !bytes.HasPrefix(v, []byte("HTTP/1.")) || v[7] < '0' || v[7] > '9' {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedVersion, v)
+	}
+	if v[7] == '0' {
+		return "1.0", nil
+	}
+	return "1.1", nil
+}
+
+// KeepAlive reports whether the client wants to keep the connection open
+// after this request: unless it sent Connection: close for HTTP/1.1, only
+// if it sent Connection: keep-alive for HTTP/1.0.
+func (r *Request) KeepAlive() bool {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return r.Headers.HasToken("Connection", "keep-alive")
+	}
+	return !r.Headers.HasToken("Connection", "close")
+}
+
+// parseExpect accepts "Expect: 100-continue", the only expectation RFC 9110
+// defines. A client that sends it waits for 100 Continue before sending a
+// non-empty body.
+func (r *Request) parseExpect() error {
+	expect, ok := r.Headers.Get("Expect")
+	if !ok {
+		return nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(expect), "100-continue") {
+		return fmt.Errorf("%w: %q", ErrExpectationFailed, expect)
+	}
+	// HTTP/1.0 clients do not wait, and cannot read interim responses.
+	if r.RequestLine.HttpVersion == "1.0" {
+		return nil
+	}
+	r.body.awaitingContinue = r.body.chunked || r.body.remaining > 0
+	return nil
+}
+
+// OnContinue registers f to send 100 Continue. It is called before the
+// first read of a body the client holds back until then.
+func (r *Request) OnContinue(f func() error) {
+	if r.body != nil {
+		r.body.sendContinue = f
+	}
+}
+
+// AwaitingContinue reports whether the client is still holding back the
+// body for a 100 Continue. Such a body cannot be discarded, the connection
+// has to be closed instead.
+func (r *Request) AwaitingContinue() bool {
+	return r.body != nil && r.body.awaitingContinue
+}
+
+// BodyError returns the error that ended reading the body, or nil if the
+// body was read completely or not read to an error.
+func (r *Request) BodyError() error {
+	if r.body == nil || r.body.err == io.EOF {
+		return nil
+	}
+	return r.body.err
+}
+
+// parseFraming decides how the body is delimited following RFC 9112
+// section 6, rejecting anything two parsers could read differently.
+func (r *Request) parseFraming() error {
+	te, hasTE := r.Headers.Get("Transfer-Encoding")
+	cl, hasCL := r.Headers.Get("Content-Length")
+	if hasTE && hasCL {
+		return ErrConflictingFraming
+	}
+	// HTTP/1.0 has no chunked encoding, so a 1.0 message that claims it
+	// was framed differently by whoever sent it (RFC 9112 section 6.1).
+	if hasTE && r.RequestLine.HttpVersion == "1.0" {
+		return fmt.Errorf("%w: Transfer-Encoding in an HTTP/1.0 request", ErrBadTransferEncoding)
+	}
+	if hasTE {
+		codings := strings.Split(te, ",")
+		for i, coding := range codings {
+			chunked := strings.EqualFold(strings.TrimSpace(coding), "chunked")
+			switch {
+			case i == len(codings)-1 && !chunked:
+				return fmt.Errorf("%w: final coding %q is not chunked", ErrBadTransferEncoding, coding)
+			case i < len(codings)-1 && chunked:
+				return fmt.Errorf("%w: chunked applied more than once", ErrBadTransferEncoding)
+			case !chunked:
+				return fmt.Errorf("%w: %q", ErrTransferCodingNotImplemented, strings.TrimSpace(coding))
+			}
+		}
+		r.body.chunked = true
+		return nil
+	}
+	if !hasCL {
+		return nil
+	}
+	if strings.Contains(cl, ",") {
+		return fmt.Errorf("%w: multiple values %q", ErrBadContentLength, cl)
+	}
+	if cl == "" || len(cl) > 18 || strings.Trim(cl, "0123456789") != "" {
+		return fmt.Errorf("%w: %q", ErrBadContentLength, cl)
+	}
+	n, _ := strconv.ParseInt(cl, 10, 64)
+	if r.limits.MaxBodyBytes > 0 && n > int64(r.limits.MaxBodyBytes) {
+		return ErrBodyTooLarge
+	}
+	r.body.remaining = n
+	return nil
+}
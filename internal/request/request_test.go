@@ -1,7 +1,9 @@
 package request
 
 import (
+	"bufio"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -79,13 +81,13 @@ func TestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", header(r, "host"))
+	assert.Equal(t, "curl/7.81.0", header(r, "user-agent"))
+	assert.Equal(t, "*/*", header(r, "accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\n\r\n",
+		data:            "GET / HTTP/1.0\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
@@ -95,7 +97,7 @@ func TestHeadersParse(t *testing.T) {
 
 	// Test: Malformed Header
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost localhost:42069\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nHost localhost:42069\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
@@ -103,13 +105,13 @@ func TestHeadersParse(t *testing.T) {
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nHost: duplicate:8080\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\nAccept: */*\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, duplicate:8080", r.Headers["host"])
+	assert.Equal(t, "text/html, */*", header(r, "accept"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -119,8 +121,8 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
+	assert.Equal(t, "localhost:42069", header(r, "host"))
+	assert.Equal(t, "curl/7.81.0", header(r, "user-agent"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
@@ -144,7 +146,7 @@ func TestBodyParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Empty Body, 0 reported content length
 	reader = &chunkReader{
@@ -157,7 +159,7 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	assert.Equal(t, "", readBody(t, r))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -169,7 +171,31 @@ func TestBodyParse(t *testing.T) {
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
-	require.Error(t, err)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Body is read lazily and can be read in pieces
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\nHost: localhost\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Less(t, reader.pos, len(reader.data))
+	p := make([]byte, 5)
+	_, err = io.ReadFull(r.Body, p)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(p))
+	assert.Equal(t, " world", readBody(t, r))
+
+	// Test: Reading after Close fails
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(p)
+	assert.ErrorIs(t, err, ErrBodyClosed)
 
 	// Test: No Content-Length but Body Exists
 	reader = &chunkReader{
@@ -182,7 +208,232 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	assert.Equal(t, "", readBody(t, r))
+}
+
+func TestChunkedBody(t *testing.T) {
+	data := "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Trailer: X-Checksum\r\n" +
+		"\r\n" +
+		"5\r\n" +
+		"hello\r\n" +
+		"7;name=value\r\n" +
+		" world!\r\n" +
+		"1A \r\n" +
+		"abcdefghijklmnopqrstuvwxyz\r\n" +
+		"0\r\n" +
+		"X-Checksum: abc123\r\n" +
+		"\r\n"
+	for _, size := range []int{1, 3, 7, 64} {
+		// Test: Chunks, extensions and trailers with odd read sizes
+		r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: size})
+		require.NoError(t, err)
+		_, ok := r.Trailers.Get("X-Checksum")
+		assert.False(t, ok)
+		assert.Equal(t, "hello world!abcdefghijklmnopqrstuvwxyz", readBody(t, r))
+		v, ok := r.Trailers.Get("X-Checksum")
+		assert.True(t, ok)
+		assert.Equal(t, "abc123", v)
+	}
+
+	// Test: Zero chunk without trailers, followed by a pipelined request
+	reader := bufio.NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\nHost: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n" +
+			"GET /second HTTP/1.1\r\nHost: localhost\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	})
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", readBody(t, r))
+	assert.Equal(t, 0, r.Trailers.Len())
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Unread chunked body is discarded
+	reader = bufio.NewReader(strings.NewReader("POST /first HTTP/1.1\r\nHost: localhost\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n0\r\nX-Done: yes\r\n\r\n" +
+		"GET /second HTTP/1.1\r\nHost: localhost\r\n" +
+		"\r\n"))
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.DiscardBody(1024))
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	// Test: Chunked body larger than the limit
+	r, err = RequestFromReaderWithLimits(&chunkReader{data: data, numBytesPerRead: 3}, Limits{MaxBodyBytes: 10})
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	bad := []struct {
+		name string
+		body string
+		err  error
+	}{
+		{"invalid size", "xyz\r\nabc\r\n0\r\n\r\n", ErrBadChunk},
+		{"signed size", "+3\r\nabc\r\n0\r\n\r\n", ErrBadChunk},
+		{"empty size", "\r\nabc\r\n0\r\n\r\n", ErrBadChunk},
+		{"size overflow", "ffffffffffffffffff\r\n", ErrBadChunk},
+		{"data longer than size", "3\r\nabcd\r\n0\r\n\r\n", ErrBadChunk},
+		{"bare LF", "3\nabc\r\n0\r\n\r\n", ErrBadChunk},
+		{"malformed trailer", "3\r\nabc\r\n0\r\nX-Bad\r\n\r\n", ErrMalformedHeader},
+		{"truncated", "3\r\nabc\r\n", io.ErrUnexpectedEOF},
+		{"truncated data", "a\r\nabc", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range bad {
+		// Test: Malformed chunked bodies fail the read
+		r, err := RequestFromReader(&chunkReader{
+			data:            "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" + tt.body,
+			numBytesPerRead: 3,
+		})
+		require.NoError(t, err, tt.name)
+		_, err = io.ReadAll(r.Body)
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"missing part", "/coffee HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrBadRequestLine},
+		{"bad method", "G3T / HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrBadMethod},
+		{"unknown method", "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrMethodNotImplemented},
+		{"unsupported version", "GET / HTTP/2.0\r\n\r\n", ErrUnsupportedVersion},
+		{"version without slash", "GET / HTTP\r\n\r\n", ErrUnsupportedVersion},
+		{"lower case version", "GET / http/1.1\r\n\r\n", ErrUnsupportedVersion},
+		{"two digit minor version", "GET / HTTP/1.10\r\n\r\n", ErrUnsupportedVersion},
+		{"garbage version", "GET / HTTP/x.y\r\n\r\n", ErrUnsupportedVersion},
+		{"HTTP/1.0 with Transfer-Encoding", "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrBadTransferEncoding},
+		{"malformed header", "GET / HTTP/1.1\r\nHost: localhost\r\nHost localhost\r\n\r\n", ErrMalformedHeader},
+		{"empty header name", "GET / HTTP/1.1\r\nHost: localhost\r\n: value\r\n\r\n", ErrMalformedHeader},
+		{"bad content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: ten\r\n\r\n", ErrBadContentLength},
+		{"negative content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: -1\r\n\r\n", ErrBadContentLength},
+		{"truncated headers", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		// Test: Each failure is reported with its typed error
+		_, err := RequestFromReader(&chunkReader{data: tt.data, numBytesPerRead: 4})
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	// Test: Consecutive requests are read from the same reader
+	reader := bufio.NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\nHost: localhost\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", readBody(t, r))
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, "localhost:42069", header(r, "host"))
+
+	// Test: Clean EOF between requests
+	_, err = RequestFromReader(reader)
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Unread body is discarded before the next request
+	reader = bufio.NewReader(strings.NewReader("POST /first HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello" +
+		"GET /second HTTP/1.1\r\nHost: localhost\r\n" +
+		"\r\n"))
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.DiscardBody(4), ErrBodyTooLarge)
+	require.NoError(t, r.DiscardBody(5))
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+
+	// Test: Empty lines before a request-line are skipped
+	reader = bufio.NewReader(strings.NewReader("POST /first HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello\r\n" +
+		"\r\n" +
+		"GET /second HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"\r\n"))
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, r))
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+}
+
+func TestLimits(t *testing.T) {
+	// Test: Header section larger than the limit
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err := RequestFromReaderWithLimits(reader, Limits{MaxHeaderBytes: 32})
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Request line larger than the limit
+	reader = &chunkReader{
+		data:            "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, Limits{MaxHeaderBytes: 32})
+	assert.ErrorIs(t, err, ErrURITooLong)
+
+	// Test: Content-Length larger than the limit
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, Limits{MaxBodyBytes: 12})
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Request within the limits
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReaderWithLimits(reader, Limits{MaxHeaderBytes: 64, MaxBodyBytes: 13})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", readBody(t, r))
+}
+
+func header(r *Request, key string) string {
+	v, _ := r.Headers.Get(key)
+	return v
+}
+
+func readBody(t *testing.T, r *Request) string {
+	t.Helper()
+	b, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	return string(b)
 }
 
 type chunkReader struct {
@@ -204,3 +455,60 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 	return n, nil
 }
+
+func TestExpectContinue(t *testing.T) {
+	// Test: 100-continue is signalled on the first body read only
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.True(t, r.AwaitingContinue())
+	assert.Error(t, r.DiscardBody(1024))
+	sent := 0
+	r.OnContinue(func() error { sent++; return nil })
+	p := make([]byte, 2)
+	_, err = r.Body.Read(p)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "llo", string(rest))
+	assert.Equal(t, 1, sent)
+	assert.False(t, r.AwaitingContinue())
+
+	// Test: Nothing to wait for without a body
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.AwaitingContinue())
+
+	// Test: Other expectations fail
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 200-ok\r\nContent-Length: 5\r\n\r\nhello"))
+	assert.ErrorIs(t, err, ErrExpectationFailed)
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 is accepted, later 1.x versions are read as 1.1
+	for version, want := range map[string]string{"HTTP/1.0": "1.0", "HTTP/1.1": "1.1", "HTTP/1.2": "1.1"} {
+		r, err := RequestFromReader(strings.NewReader("GET / " + version + "\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err, version)
+		assert.Equal(t, want, r.RequestLine.HttpVersion, version)
+	}
+
+	// Test: HTTP/1.0 closes by default, HTTP/1.1 keeps the connection
+	keepAlive := []struct {
+		request string
+		want    bool
+	}{
+		{"GET / HTTP/1.0\r\n\r\n", false},
+		{"GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n", true},
+		{"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", true},
+		{"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n", false},
+	}
+	for _, tt := range keepAlive {
+		r, err := RequestFromReader(strings.NewReader(tt.request))
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, r.KeepAlive(), tt.request)
+	}
+
+	// Test: HTTP/1.0 clients do not wait for 100 Continue
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.False(t, r.AwaitingContinue())
+}
@@ -0,0 +1,84 @@
+package request
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Payloads that front ends and back ends have been known to frame
+// differently. Every one must be rejected, either while reading the head or
+// while reading the body.
+var smugglingCorpus = []struct {
+	name string
+	data string
+	err  error
+}{
+	{"CL.TE", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 13\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nSMUGGLED", ErrConflictingFraming},
+	{"TE.CL", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n", ErrConflictingFraming},
+	{"duplicate CL", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello", ErrBadContentLength},
+	{"conflicting CL", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 10\r\n\r\nhelloGET /x", ErrBadContentLength},
+	{"CL list", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5, 10\r\n\r\nhello", ErrBadContentLength},
+	{"CL with sign", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: +5\r\n\r\nhello", ErrBadContentLength},
+	{"CL in hex", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0x5\r\n\r\nhello", ErrBadContentLength},
+	{"CL with inner space", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1 0\r\n\r\nhello", ErrBadContentLength},
+	{"CL overflow", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 99999999999999999999\r\n\r\n", ErrBadContentLength},
+	{"TE not chunked", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: identity\r\n\r\nhello", ErrBadTransferEncoding},
+	{"TE xchunked", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n", ErrBadTransferEncoding},
+	{"TE chunked then identity", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n", ErrBadTransferEncoding},
+	{"TE chunked twice", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrBadTransferEncoding},
+	{"TE trailing comma", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked,\r\n\r\n0\r\n\r\n", ErrBadTransferEncoding},
+	{"TE unknown coding", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n", ErrTransferCodingNotImplemented},
+	{"space before colon", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n", ErrMalformedHeader},
+	{"tab before colon", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length\t: 5\r\n\r\nhello", ErrMalformedHeader},
+	{"obs-fold", "POST / HTTP/1.1\r\nHost: localhost\r\nX-Padding: a\r\n Transfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrMalformedHeader},
+	{"leading tab", "POST / HTTP/1.1\r\nHost: localhost\r\n\tTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrMalformedHeader},
+	{"bare LF between headers", "POST / HTTP/1.1\r\nHost: localhost\r\nX-Padding: a\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrMalformedHeader},
+	{"bare CR between headers", "POST / HTTP/1.1\r\nHost: localhost\r\nX-Padding: a\rContent-Length: 5\r\n\r\nhello", ErrMalformedHeader},
+	{"bare LF in request line", "GET /\nX HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrBadRequestLine},
+	{"bare CR in request line", "GET /\rX HTTP/1.1\r\nHost: localhost\r\n\r\n", ErrBadRequestLine},
+	{"chunk size with sign", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n-5\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+	{"chunk size with prefix", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0x5\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+	{"chunk size overflow", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n10000000000000005\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+	{"chunk line bare LF", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n", ErrBadChunk},
+	{"chunk extension bare CR", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5;a\rb\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+	{"chunk data overrun", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n", ErrBadChunk},
+	{"missing Host", "GET / HTTP/1.1\r\n\r\n", ErrBadHost},
+	{"duplicate Host", "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", ErrBadHost},
+	{"duplicate Host in HTTP/1.0", "GET / HTTP/1.0\r\nHost: a\r\nHost: b\r\n\r\n", ErrBadHost},
+	{"trailer space before colon", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nContent-Length : 5\r\n\r\n", ErrMalformedHeader},
+}
+
+func TestSmugglingCorpus(t *testing.T) {
+	for _, tt := range smugglingCorpus {
+		// Test: Ambiguous framing is rejected
+		r, err := RequestFromReader(&chunkReader{data: tt.data, numBytesPerRead: 5})
+		if err == nil {
+			_, err = io.ReadAll(r.Body)
+		}
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+}
+
+func TestUnambiguousFraming(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		body string
+	}{
+		{"chunked in any case", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: Chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", "hello"},
+		{"tab as whitespace", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding:\tchunked\t\r\n\r\n5\r\nhello\r\n0\r\n\r\n", "hello"},
+		{"chunk extension with whitespace", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5 ;a=b\r\nhello\r\n0\r\n\r\n", "hello"},
+		{"leading zeros", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 005\r\n\r\nhello", "hello"},
+	}
+	for _, tt := range tests {
+		// Test: Well-formed variants are still accepted
+		r, err := RequestFromReader(&chunkReader{data: tt.data, numBytesPerRead: 5})
+		require.NoError(t, err, tt.name)
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.body, string(b), tt.name)
+	}
+}
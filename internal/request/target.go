@@ -0,0 +1,228 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var ErrBadTarget = errors.New("invalid request target")
+
+// URL is a parsed request target. Path is percent-decoded and cleaned with
+// CleanPath, so it never leaves the root. RawPath is the path as it was
+// sent. Scheme is only set for absolute-form targets and
+// Host comes from the target when it has one, otherwise from the Host
+// header.
+type URL struct {
+	Scheme   string
+	Host     string
+	Path     string
+	RawPath  string
+	RawQuery string
+	Query    Query
+	Fragment string
+}
+
+// Query maps decoded query parameter names to all their values in order.
+type Query map[string][]string
+
+// Get returns the first value of key, or "".
+func (q Query) Get(key string) string {
+	if vs := q[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// ParseTarget parses a request target in one of the four forms of RFC 9112
+// section 3.2: origin-form ("/path?query"), absolute-form
+// ("http://host/path"), authority-form ("host:port", CONNECT only) and
+// asterisk-form ("*", OPTIONS only).
+func ParseTarget(method, target string) (*URL, error) {
+	for i := 0; i < len(target); i++ {
+		if c := target[i]; c <= ' ' || c >= 0x7f {
+			return nil, fmt.Errorf("%w: invalid character %q", ErrBadTarget, c)
+		}
+	}
+	switch {
+	case method == "CONNECT":
+		return parseAuthorityForm(target)
+	case target == "*":
+		if method != "OPTIONS" {
+			return nil, fmt.Errorf("%w: * is only allowed for OPTIONS", ErrBadTarget)
+		}
+		return &URL{Path: "*", RawPath: "*"}, nil
+	case strings.HasPrefix(target, "/"):
+		u := &URL{}
+		return u, u.parsePathQuery(target)
+	}
+
+	scheme, rest, ok := strings.Cut(target, "://")
+	if !ok || !validScheme(scheme) {
+		return nil, fmt.Errorf("%w: %q", ErrBadTarget, target)
+	}
+	u := &URL{Scheme: strings.ToLower(scheme)}
+	end := strings.IndexAny(rest, "/?#")
+	if end == -1 {
+		end = len(rest)
+	}
+	u.Host = rest[:end]
+	if u.Host == "" || strings.Contains(u.Host, "@") {
+		return nil, fmt.Errorf("%w: invalid host in %q", ErrBadTarget, target)
+	}
+	pathQuery := rest[end:]
+	if !strings.HasPrefix(pathQuery, "/") {
+		pathQuery = "/" + pathQuery
+	}
+	return u, u.parsePathQuery(pathQuery)
+}
+
+func parseAuthorityForm(target string) (*URL, error) {
+	host, port, ok := strings.Cut(target, ":")
+	if i := strings.LastIndex(target, "]:"); strings.HasPrefix(target, "[") && i != -1 {
+		host, port, ok = target[:i+1], target[i+2:], true
+	}
+	if !ok || host == "" || port == "" || strings.Trim(port, "0123456789") != "" || strings.ContainsAny(host, "/?#@") {
+		return nil, fmt.Errorf("%w: CONNECT needs host:port, got %q", ErrBadTarget, target)
+	}
+	return &URL{Host: target}, nil
+}
+
+func (u *URL) parsePathQuery(s string) error {
+	s, u.Fragment, _ = strings.Cut(s, "#")
+	u.RawPath, u.RawQuery, _ = strings.Cut(s, "?")
+	decoded, err := unescape(u.RawPath, false)
+	if err != nil {
+		return err
+	}
+	if strings.IndexByte(decoded, 0) != -1 {
+		return fmt.Errorf("%w: NUL in path", ErrBadTarget)
+	}
+	u.Path = CleanPath(decoded)
+	if u.RawQuery == "" {
+		return nil
+	}
+	u.Query = Query{}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, "=")
+		key, err := unescape(k, true)
+		if err != nil {
+			return err
+		}
+		value, err := unescape(v, true)
+		if err != nil {
+			return err
+		}
+		u.Query[key] = append(u.Query[key], value)
+	}
+	return nil
+}
+
+// CleanPath returns the canonical form of a decoded path. It resolves "."
+// and ".." segments without ever climbing above "/", collapses repeated
+// slashes and keeps a trailing slash.
+func CleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	trailing := strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")
+	if trailing && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// EscapePath percent-encodes a decoded path the canonical way: every byte
+// outside the unreserved and sub-delims sets, ':', '@' and '/' becomes an
+// upper-case %XX escape.
+func EscapePath(p string) string {
+	n := 0
+	for i := 0; i < len(p); i++ {
+		if !pathChar(p[i]) {
+			n++
+		}
+	}
+	if n == 0 {
+		return p
+	}
+	const hex = "0123456789ABCDEF"
+	b := make([]byte, 0, len(p)+2*n)
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if pathChar(c) {
+			b = append(b, c)
+			continue
+		}
+		b = append(b, '%', hex[c>>4], hex[c&15])
+	}
+	return string(b)
+}
+
+func pathChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-._~!$&'()*+,;=:@/", c) != -1
+}
+
+// unescape decodes %XX escapes, and '+' as a space in query components. It
+// returns s itself when there is nothing to decode.
+func unescape(s string, query bool) (string, error) {
+	if !strings.ContainsRune(s, '%') && !(query && strings.ContainsRune(s, '+')) {
+		return s, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", fmt.Errorf("%w: bad escape in %q", ErrBadTarget, s)
+			}
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case c == '+' && query:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+func validScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		letter := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if !letter && (i == 0 || (c < '0' || c > '9') && c != '+' && c != '-' && c != '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	}
+	return c - 'a' + 10
+}
@@ -0,0 +1,161 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	// Test: Origin-form with query and fragment
+	u, err := ParseTarget("GET", "/caf%C3%A9/menu?size=large&add=milk&add=sugar+syrup#top")
+	require.NoError(t, err)
+	assert.Equal(t, "/café/menu", u.Path)
+	assert.Equal(t, "/caf%C3%A9/menu", u.RawPath)
+	assert.Equal(t, "size=large&add=milk&add=sugar+syrup", u.RawQuery)
+	assert.Equal(t, "large", u.Query.Get("size"))
+	assert.Equal(t, []string{"milk", "sugar syrup"}, u.Query["add"])
+	assert.Equal(t, "top", u.Fragment)
+	assert.Empty(t, u.Scheme)
+	assert.Empty(t, u.Host)
+
+	// Test: Origin-form without query
+	u, err = ParseTarget("GET", "/video")
+	require.NoError(t, err)
+	assert.Equal(t, "/video", u.Path)
+	assert.Nil(t, u.Query)
+	assert.Equal(t, "", u.Query.Get("missing"))
+
+	// Test: Absolute-form
+	u, err = ParseTarget("GET", "HTTP://example.com:8080/a%2Fb?x=%41")
+	require.NoError(t, err)
+	assert.Equal(t, "http", u.Scheme)
+	assert.Equal(t, "example.com:8080", u.Host)
+	assert.Equal(t, "/a/b", u.Path)
+	assert.Equal(t, "/a%2Fb", u.RawPath)
+	assert.Equal(t, "A", u.Query.Get("x"))
+
+	// Test: Absolute-form without a path
+	u, err = ParseTarget("GET", "http://example.com?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", u.Host)
+	assert.Equal(t, "/", u.Path)
+	assert.Equal(t, "x=1", u.RawQuery)
+
+	// Test: Authority-form for CONNECT
+	u, err = ParseTarget("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", u.Host)
+	assert.Empty(t, u.Path)
+	u, err = ParseTarget("CONNECT", "[::1]:443")
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:443", u.Host)
+
+	// Test: Asterisk-form for OPTIONS
+	u, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, "*", u.Path)
+
+	bad := []struct{ method, target string }{
+		{"GET", ""},
+		{"GET", "video"},
+		{"GET", "*"},
+		{"GET", "/bad%zzescape"},
+		{"GET", "/truncated%4"},
+		{"GET", "/?q=%"},
+		{"GET", "/caf\xc3\xa9"},
+		{"GET", "/tab\there"},
+		{"GET", "/etc/passwd%00.png"},
+		{"GET", "1http://example.com/"},
+		{"GET", "http:///path"},
+		{"GET", "http://user@example.com/"},
+		{"CONNECT", "/path"},
+		{"CONNECT", "example.com"},
+		{"CONNECT", "example.com:https"},
+	}
+	for _, tt := range bad {
+		// Test: Invalid targets are rejected
+		_, err := ParseTarget(tt.method, tt.target)
+		assert.ErrorIs(t, err, ErrBadTarget, tt.method+" "+tt.target)
+	}
+}
+
+func TestRequestURL(t *testing.T) {
+	// Test: Parsed URL takes its host from the Host header
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET /video?x=1 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/video?x=1", r.RequestLine.RequestTarget)
+	assert.Equal(t, "/video", r.URL.Path)
+	assert.Equal(t, "1", r.URL.Query.Get("x"))
+	assert.Equal(t, "localhost:42069", r.URL.Host)
+
+	// Test: Absolute-form host wins over the Host header
+	r, err = RequestFromReader(&chunkReader{
+		data:            "GET http://example.com/ HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", r.URL.Host)
+
+	// Test: Invalid target fails the parse
+	_, err = RequestFromReader(&chunkReader{
+		data:            "GET video HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	assert.ErrorIs(t, err, ErrBadTarget)
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", "/"},
+		{"/", "/"},
+		{"/static/css/site.css", "/static/css/site.css"},
+		{"/static/../../etc/passwd", "/etc/passwd"},
+		{"/../../../", "/"},
+		{"/..", "/"},
+		{"/a/./b/../c", "/a/c"},
+		{"/a/b/..", "/a/"},
+		{"/a/b/.", "/a/b/"},
+		{"//static///css//", "/static/css/"},
+		{"static/file", "/static/file"},
+		{"/a/...", "/a/..."},
+	}
+	for _, tt := range tests {
+		// Test: Dot-segments and duplicate slashes are resolved
+		assert.Equal(t, tt.out, CleanPath(tt.in), tt.in)
+	}
+
+	traversals := []string{
+		"/static/../../etc/passwd",
+		"/static/%2e%2e/%2e%2e/etc/passwd",
+		"/static/%2E%2E%2F%2E%2E%2Fetc/passwd",
+		"/static/.%2e/.%2e/etc/passwd",
+		"/static//..//..//etc/passwd",
+		"/%2e%2e/%2e%2e/%2e%2e/etc/passwd",
+	}
+	for _, target := range traversals {
+		// Test: Encoded traversal cannot climb above the root
+		u, err := ParseTarget("GET", target)
+		require.NoError(t, err, target)
+		assert.Equal(t, "/etc/passwd", u.Path, target)
+		assert.Equal(t, target, u.RawPath)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	// Test: Canonical escaping round-trips through ParseTarget
+	for _, p := range []string{"/", "/café/menu", "/a b/100%", "/~user/x:y@z;v=1"} {
+		escaped := EscapePath(p)
+		u, err := ParseTarget("GET", escaped)
+		require.NoError(t, err, p)
+		assert.Equal(t, p, u.Path)
+	}
+
+	// Test: Hex digits are upper case and safe characters stay as they are
+	assert.Equal(t, "/caf%C3%A9/a%20b/100%25", EscapePath("/café/a b/100%"))
+	assert.Equal(t, "/~user/x:y@z;v=1", EscapePath("/~user/x:y@z;v=1"))
+}
@@ -0,0 +1,59 @@
+package request
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+)
+
+// TLSInfo describes the TLS connection a request arrived on. It is nil for
+// plaintext requests.
+type TLSInfo struct {
+	ServerName       string
+	PeerCertificates []*x509.Certificate
+	VerifiedChains   [][]*x509.Certificate
+}
+
+func NewTLSInfo(state tls.ConnectionState) *TLSInfo {
+	return &TLSInfo{
+		ServerName:       state.ServerName,
+		PeerCertificates: state.PeerCertificates,
+		VerifiedChains:   state.VerifiedChains,
+	}
+}
+
+// ClientCertificate returns the client's leaf certificate when it was
+// verified against the server's client CAs, and nil otherwise.
+func (t *TLSInfo) ClientCertificate() *x509.Certificate {
+	if t == nil || len(t.VerifiedChains) == 0 || len(t.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return t.VerifiedChains[0][0]
+}
+
+func (t *TLSInfo) Subject() pkix.Name {
+	cert := t.ClientCertificate()
+	if cert == nil {
+		return pkix.Name{}
+	}
+	return cert.Subject
+}
+
+// SANs returns the DNS, email, IP and URI subject alternative names of the
+// verified client certificate.
+func (t *TLSInfo) SANs() []string {
+	cert := t.ClientCertificate()
+	if cert == nil {
+		return nil
+	}
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.IPAddresses)+len(cert.URIs))
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
@@ -4,26 +4,37 @@ import (
 	"fmt"
 )
 
+// WriteChunkedBody writes p as one chunk, switching a body whose length is
+// not declared to chunked framing.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.state != writerStateBody {
+		return 0, fmt.Errorf("%w: chunk before headers or after the response was finished", ErrOutOfOrder)
+	}
+	if w.contentLength >= 0 {
+		return 0, fmt.Errorf("%w: chunk in a body with Content-Length", ErrFraming)
+	}
+	w.chunked = true
+	return w.Write(p)
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
-	size := len(p)
-	_, err := w.WriteBody([]byte(fmt.Sprintf("%x\r\n", size)))
+	if w.http10 {
+		return w.out.Write(p)
+	}
+	_, err := w.out.Write([]byte(fmt.Sprintf("%x\r\n", len(p))))
 	if err != nil {
 		return 0, err
 	}
-	_, err = w.WriteBody(p)
+	n, err := w.out.Write(p)
 	if err != nil {
-		return 0, err
+		return n, err
 	}
-	_, err = w.WriteBody([]byte("\r\n"))
+	_, err = w.out.Write([]byte("\r\n"))
 	if err != nil {
-		return 0, err
+		return n, err
 	}
-	return size, nil
-}
-
-func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	return w.WriteBody([]byte("0\r\n"))
+	return n, nil
 }
@@ -0,0 +1,33 @@
+package response
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TimeFormat is the IMF-fixdate format of RFC 9110 section 5.6.7, used by
+// the Date header.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type cachedDate struct {
+	unix  int64
+	value string
+}
+
+var currentDate atomic.Pointer[cachedDate]
+
+// Date returns the current time for the Date header. It is formatted at
+// most once a second.
+func Date() string {
+	return dateAt(time.Now())
+}
+
+func dateAt(now time.Time) string {
+	unix := now.Unix()
+	if d := currentDate.Load(); d != nil && d.unix == unix {
+		return d.value
+	}
+	d := &cachedDate{unix: unix, value: now.UTC().Format(TimeFormat)}
+	currentDate.Store(d)
+	return d.value
+}
@@ -1,111 +1,521 @@
 package response
 
 import (
+	"errors"
+	"fmt"
 	"httpserver/internal/headers"
 	"io"
 	"strconv"
+	"strings"
 )
 
+// Writer writes one response and enforces the order of its parts: status
+// line, headers, body, trailers. Calls out of that order fail with an
+// error wrapping ErrOutOfOrder.
+//
+// The Writer also frames the body. Unless the handler sets Content-Length
+// or Transfer-Encoding itself, up to bufferSize bytes of body are held back
+// and sent with a Content-Length when the response is finished. Larger
+// bodies, and bodies that are flushed early, are sent chunked.
 type Writer struct {
-	io.Writer
+	out           io.Writer
+	state         writerState
+	status        StatusCode
+	header        *headers.Headers
+	sent          *headers.Headers
+	beforeHeaders []func(*headers.Headers)
+	bodyBytes     int
+	// contentLength is the declared body length, or -1 when the Writer
+	// picks the framing.
+	contentLength int
+	chunked       bool
+	buf           []byte
+	trailerNames  []string
+	trailer       *headers.Headers
+	omitBody      bool
+	http10        bool
+	renderError   func(code StatusCode, message string)
 }
 
-func NewWriter(w io.Writer) *Writer {
-	return &Writer{w}
-}
-
-type StatusCode int
+type writerState int
 
 const (
-	OK                    StatusCode = 200
-	BAD_REQUEST           StatusCode = 400
-	NOT_FOUND             StatusCode = 404
-	INTERNAL_SERVER_ERROR StatusCode = 500
+	writerStateStatus writerState = iota
+	writerStateHeaders
+	writerStateBody
+	writerStateDone
 )
 
-func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	switch statusCode {
-	case OK:
-		_, err := w.Write([]byte("HTTP/1.1 200 OK\r\n"))
+var (
+	ErrOutOfOrder = errors.New("response written out of order")
+	// ErrFraming is returned when the body does not match the
+	// Content-Length or Transfer-Encoding the handler set.
+	ErrFraming = errors.New("response body does not match its framing")
+	// ErrBadTrailer is returned for trailer fields that were not declared
+	// or must not be sent as trailers.
+	ErrBadTrailer = errors.New("undeclared or forbidden trailer field")
+)
+
+// bufferSize is how much body the Writer holds back to send it with a
+// Content-Length instead of chunked.
+const bufferSize = 4 << 10
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{out: w, contentLength: -1}
+}
+
+func GetDefaultHeaders(contentLen int) *headers.Headers {
+	h := headers.NewHeaders()
+	h.Add("Content-Length", strconv.Itoa(contentLen))
+	h.Add("Content-Type", "text/plain")
+	return h
+}
+
+// WriteHeaders writes the header section. Nothing is written when a field
+// fails headers.Validate, so that a value taken from user input can never
+// add fields of its own or end the header section early.
+func WriteHeaders(w io.Writer, headers *headers.Headers) error {
+	if err := headers.Validate(); err != nil {
+		return err
+	}
+	for key, value := range headers.All() {
+		_, err := w.Write([]byte(key + ": " + value + "\r\n"))
 		if err != nil {
 			return err
 		}
-		return nil
-	case BAD_REQUEST:
-		_, err := w.Write([]byte("HTTP/1.1 400 Bad Request\r\n"))
-		if err != nil {
-			return err
+	}
+	_, err := w.Write([]byte("\r\n"))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Write writes p as part of the body. When nothing has been written yet it
+// first writes an implicit 200 status line, and when WriteHeaders has not
+// been called it adds a text/plain Content-Type unless another one is set.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.state != writerStateBody && w.state != writerStateDone {
+		if _, ok := w.Header().Get("Content-Type"); !ok {
+			w.Header().Add("Content-Type", "text/plain")
 		}
+	}
+	if err := w.startBody(); err != nil {
+		return 0, err
+	}
+	if w.state != writerStateBody {
+		return 0, fmt.Errorf("%w: body after the response was finished", ErrOutOfOrder)
+	}
+	if !BodyAllowed(w.status) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%w: %d responses have no body", ErrFraming, w.status)
+	}
+	if w.contentLength < 0 && !w.chunked {
+		if len(w.buf)+len(p) <= bufferSize {
+			w.buf = append(w.buf, p...)
+			w.bodyBytes += len(p)
+			return len(p), nil
+		}
+		w.chunked = true
+	}
+	if w.contentLength >= 0 && w.bodyBytes+len(p) > w.contentLength {
+		return 0, fmt.Errorf("%w: body longer than Content-Length %d", ErrFraming, w.contentLength)
+	}
+	if err := w.writeHeaderSection(); err != nil {
+		return 0, err
+	}
+	if w.omitBody {
+		w.bodyBytes += len(p)
+		return len(p), nil
+	}
+	var n int
+	var err error
+	if w.chunked {
+		n, err = w.writeChunk(p)
+	} else {
+		n, err = w.out.Write(p)
+	}
+	w.bodyBytes += n
+	return n, err
+}
+
+// Flush sends everything written so far. A body whose length is not known
+// yet is sent chunked from then on.
+func (w *Writer) Flush() error {
+	if err := w.startBody(); err != nil {
+		return err
+	}
+	if w.state != writerStateBody {
 		return nil
-	case NOT_FOUND:
-		_, err := w.Write([]byte("HTTP/1.1 404 Not Found\r\n"))
-		if err != nil {
-			return err
+	}
+	if w.contentLength < 0 && BodyAllowed(w.status) {
+		w.chunked = true
+	}
+	return w.writeHeaderSection()
+}
+
+// Finish completes the response: it writes whatever is still missing of
+// the status line and headers, sends a held back body with its
+// Content-Length and ends a chunked body with the trailers set so far. The
+// server calls it when the handler returns. An error means the response is
+// incomplete and the connection cannot be reused.
+func (w *Writer) Finish() error {
+	if err := w.startBody(); err != nil {
+		return err
+	}
+	if w.state != writerStateBody {
+		return nil
+	}
+	if err := w.writeHeaderSection(); err != nil {
+		return err
+	}
+	w.state = writerStateDone
+	if !BodyAllowed(w.status) || w.omitBody || w.chunked && w.http10 {
+		return nil
+	}
+	if !w.chunked {
+		if w.bodyBytes != w.contentLength {
+			return fmt.Errorf("%w: %d of %d body bytes written", ErrFraming, w.bodyBytes, w.contentLength)
 		}
 		return nil
-	case INTERNAL_SERVER_ERROR:
-		_, err := w.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
-		if err != nil {
+	}
+	if _, err := w.out.Write([]byte("0\r\n")); err != nil {
+		return err
+	}
+	if w.trailer == nil {
+		w.trailer = headers.NewHeaders()
+	}
+	return WriteHeaders(w.out, w.trailer)
+}
+
+// startBody writes an implicit 200 status line and the pending headers when
+// the handler has not.
+func (w *Writer) startBody() error {
+	if w.state == writerStateStatus {
+		if err := w.WriteStatusLine(OK); err != nil {
 			return err
 		}
+	}
+	if w.state == writerStateHeaders {
+		return w.WriteHeaders(headers.NewHeaders())
+	}
+	return nil
+}
+
+// writeHeaderSection puts the headers on the wire, with the framing fields
+// the Writer picked, followed by the body held back so far.
+func (w *Writer) writeHeaderSection() error {
+	if w.sent != nil {
 		return nil
-	default:
-		_, err := w.Write([]byte("HTTP/1.1 " + strconv.Itoa(int(statusCode)) + " Unknown Status Code\r\n"))
-		if err != nil {
-			return err
+	}
+	h := w.header
+	if !BodyAllowed(w.status) {
+		return w.writeHeaderFields(h)
+	}
+	if w.http10 {
+		// Trailers can only follow a chunked body.
+		h.Del("Trailer")
+	} else {
+		for _, name := range w.trailerNames {
+			if !h.HasToken("Trailer", name) {
+				h.Set("Trailer", name)
+			}
 		}
+	}
+	if _, ok := h.Get("Trailer"); ok && w.contentLength < 0 {
+		w.chunked = true
+	}
+	switch {
+	case w.chunked && w.http10:
+		// The body ends where the connection does.
+		h.Del("Transfer-Encoding")
+		h.Overwrite("Connection", "close")
+	case w.chunked:
+		h.Overwrite("Transfer-Encoding", "chunked")
+	case w.contentLength < 0:
+		w.contentLength = len(w.buf)
+		h.Overwrite("Content-Length", strconv.Itoa(w.contentLength))
+	}
+	if err := w.writeHeaderFields(h); err != nil {
+		return err
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 || w.omitBody {
 		return nil
 	}
+	if w.chunked {
+		_, err := w.writeChunk(buf)
+		return err
+	}
+	_, err := w.out.Write(buf)
+	return err
+}
+
+// OmitBody makes the Writer drop the body, as a response to HEAD must. The
+// headers, including a Content-Length taken from the dropped body, are
+// the same as without it.
+func (w *Writer) OmitBody() {
+	w.omitBody = true
+}
+
+// SetHTTP10 adapts the response to an HTTP/1.0 client, which can read
+// neither chunked bodies nor interim responses. A body of unknown length is
+// sent as it is and ended by closing the connection, its trailers are
+// dropped, and WriteInformational does nothing.
+func (w *Writer) SetHTTP10() {
+	w.http10 = true
+}
+
+// Committed reports whether any part of the final response has been sent.
+// Informational responses do not count.
+func (w *Writer) Committed() bool {
+	return w.state != writerStateStatus
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
-	return headers.Headers{
-		"Content-Length": strconv.Itoa(contentLen),
-		"Content-Type":   "text/plain",
-		"Connection":     "close",
+// Status returns the status code sent, or 0 if there was none yet.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// BytesWritten returns the number of body bytes written, not counting
+// chunked framing.
+func (w *Writer) BytesWritten() int {
+	return w.bodyBytes
+}
+
+// WriteInformational sends an interim 1xx response with the fields in h,
+// which may be nil, for example 103 Early Hints with Link fields. Any
+// number of them can precede the final status line.
+func (w *Writer) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if w.state != writerStateStatus {
+		return fmt.Errorf("%w: informational response after the status line", ErrOutOfOrder)
+	}
+	if w.http10 {
+		return nil
 	}
+	// 101 hands the connection over to another protocol, which this
+	// server does not do.
+	if statusCode < 100 || statusCode > 199 || statusCode == SWITCHING_PROTOCOLS {
+		return fmt.Errorf("%w: %d is not an informational status", ErrBadStatus, statusCode)
+	}
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	if err := WriteStatusLine(w.out, statusCode); err != nil {
+		return err
+	}
+	return WriteHeaders(w.out, h)
 }
 
-func WriteHeaders(w io.Writer, headers headers.Headers) error {
-	for key, value := range headers {
-		_, err := w.Write([]byte(key + ": " + value + "\r\n"))
-		if err != nil {
-			return err
-		}
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineReason is like WriteStatusLine with a custom reason
+// phrase, for example the one a proxied server sent.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
+	if w.state != writerStateStatus {
+		return fmt.Errorf("%w: status line already written", ErrOutOfOrder)
 	}
-	_, err := w.Write([]byte("\r\n"))
-	if err != nil {
+	// A 1xx is never the final response, the client would keep waiting.
+	if statusCode >= 100 && statusCode <= 199 {
+		return fmt.Errorf("%w: %d is informational, use WriteInformational", ErrBadStatus, statusCode)
+	}
+	if err := writeStatusLine(w.out, statusCode, reason); err != nil {
 		return err
 	}
+	w.status = statusCode
+	w.state = writerStateHeaders
 	return nil
 }
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	return WriteStatusLine(w, statusCode)
+// WriteHeaders sets the headers to send, those set through Header merged
+// with h, where h wins on conflicts. They go out together with the first
+// part of the body. If a field is invalid, or Content-Length and
+// Transfer-Encoding cannot be honored, the pending headers are dropped.
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	switch w.state {
+	case writerStateStatus:
+		return fmt.Errorf("%w: headers before status line", ErrOutOfOrder)
+	case writerStateBody, writerStateDone:
+		return fmt.Errorf("%w: headers already written", ErrOutOfOrder)
+	}
+	out := w.Header()
+	for key := range h.All() {
+		out.Del(key)
+	}
+	for key, value := range h.All() {
+		out.Add(key, value)
+	}
+	for _, f := range w.beforeHeaders {
+		f(out)
+	}
+	if err := w.setFraming(out); err != nil {
+		// Forget the offending fields so that an error response can
+		// still be written.
+		w.header = nil
+		return err
+	}
+	w.header = out
+	w.state = writerStateBody
+	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	return WriteHeaders(w, headers)
+func (w *Writer) writeHeaderFields(h *headers.Headers) error {
+	if err := WriteHeaders(w.out, h); err != nil {
+		return err
+	}
+	w.sent, w.header = h, nil
+	return nil
 }
 
-func (w *Writer) WriteBody(body []byte) (int, error) {
-	n, err := w.Write(body)
-	if err != nil {
-		return n, err
+func (w *Writer) setFraming(h *headers.Headers) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+	length, hasLength := h.Get("Content-Length")
+	te, hasTE := h.Get("Transfer-Encoding")
+	trailers, hasTrailers := h.Get("Trailer")
+	if hasTrailers {
+		for _, name := range strings.Split(trailers, ",") {
+			if name = strings.TrimSpace(name); !allowedTrailer(name) {
+				return fmt.Errorf("%w: %q", ErrBadTrailer, name)
+			}
+		}
+	}
+	if !BodyAllowed(w.status) {
+		// A 304 may announce the length of the representation it
+		// stands for.
+		if hasTE || hasLength && w.status != NOT_MODIFIED {
+			return fmt.Errorf("%w: %d responses have no body", ErrFraming, w.status)
+		}
+		return nil
 	}
-	return n, nil
+	switch {
+	case hasLength && (hasTE || hasTrailers || len(w.trailerNames) > 0):
+		return fmt.Errorf("%w: Content-Length with chunked framing or trailers", ErrFraming)
+	case hasTE:
+		if !strings.EqualFold(te, "chunked") {
+			return fmt.Errorf("%w: Transfer-Encoding %q", ErrFraming, te)
+		}
+		w.chunked = true
+	case hasLength:
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: Content-Length %q", ErrFraming, length)
+		}
+		w.contentLength = n
+	}
+	return nil
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-	for key, value := range h {
-		_, err := w.Write([]byte(key + ": " + value + "\r\n"))
-		if err != nil {
-			return err
+// Header returns the headers that have not been sent yet. Before
+// WriteHeaders they are sent in addition to its own, after it they are the
+// merged set. Middleware uses it to add response headers.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// BeforeHeaders registers f to run just before the headers are written,
+// when the final header set can still be changed.
+func (w *Writer) BeforeHeaders(f func(*headers.Headers)) {
+	w.beforeHeaders = append(w.beforeHeaders, f)
+}
+
+// OnError registers f to write the error responses RenderError is asked
+// for. The server registers the renderer it was configured with.
+func (w *Writer) OnError(f func(code StatusCode, message string)) {
+	w.renderError = f
+}
+
+// RenderError writes an error response with the function registered with
+// OnError and reports whether there was one.
+func (w *Writer) RenderError(code StatusCode, message string) bool {
+	if w.renderError == nil {
+		return false
+	}
+	w.renderError(code, message)
+	return true
+}
+
+// SentHeaders returns the headers written so far, or nil.
+func (w *Writer) SentHeaders() *headers.Headers {
+	return w.sent
+}
+
+func (w *Writer) WriteBody(body []byte) (int, error) {
+	return w.Write(body)
+}
+
+// DeclareTrailer announces trailer fields in the Trailer header. Only
+// declared fields can be set with SetTrailer, and declaring any makes the
+// body chunked. It must be called before the headers are sent.
+func (w *Writer) DeclareTrailer(names ...string) error {
+	if w.sent != nil || w.state == writerStateDone {
+		return fmt.Errorf("%w: trailer declared after the headers were sent", ErrOutOfOrder)
+	}
+	if w.contentLength >= 0 {
+		return fmt.Errorf("%w: trailers in a body with Content-Length", ErrFraming)
+	}
+	for _, name := range names {
+		if !headers.ValidName(name) || !allowedTrailer(name) {
+			return fmt.Errorf("%w: %q", ErrBadTrailer, name)
 		}
 	}
-	_, err := w.Write([]byte("\r\n"))
-	if err != nil {
-		return err
+	w.trailerNames = append(w.trailerNames, names...)
+	return nil
+}
+
+// SetTrailer sets the value of a declared trailer field. Trailers are sent
+// by Finish.
+func (w *Writer) SetTrailer(key, value string) error {
+	if w.state == writerStateDone {
+		return fmt.Errorf("%w: trailer after the response was finished", ErrOutOfOrder)
+	}
+	if !headers.ValidName(key) || !headers.ValidValue(value) {
+		return fmt.Errorf("%w: trailer %s %q", headers.ErrInvalidField, key, value)
 	}
+	if !w.trailerDeclared(key) {
+		return fmt.Errorf("%w: %s not declared", ErrBadTrailer, key)
+	}
+	if w.trailer == nil {
+		w.trailer = headers.NewHeaders()
+	}
+	w.trailer.Overwrite(key, value)
 	return nil
 }
+
+func (w *Writer) trailerDeclared(name string) bool {
+	for _, declared := range w.trailerNames {
+		if strings.EqualFold(declared, name) {
+			return true
+		}
+	}
+	h := w.sent
+	if h == nil {
+		h = w.header
+	}
+	return h != nil && h.HasToken("Trailer", name)
+}
+
+// allowedTrailer rejects the fields RFC 9110 section 6.5.1 rules out as
+// trailers: those needed for framing, routing or authentication, or that
+// describe the header section itself.
+func allowedTrailer(name string) bool {
+	switch strings.ToLower(name) {
+	case "", "content-length", "transfer-encoding", "trailer", "host",
+		"content-type", "content-encoding", "content-range",
+		"authorization", "set-cookie", "cache-control", "expect", "te":
+		return false
+	}
+	return true
+}
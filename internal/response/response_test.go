@@ -0,0 +1,338 @@
+package response
+
+import (
+	"bytes"
+	"httpserver/internal/headers"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var maliciousValues = []string{
+	"en\r\nSet-Cookie: session=stolen",
+	"en\r\n\r\n<script>alert(1)</script>",
+	"en\nSet-Cookie: session=stolen",
+	"en\rSet-Cookie: session=stolen",
+	"en\x00",
+	"en\x7f",
+	"en\x1b[31m",
+}
+
+func TestWriteHeadersInjection(t *testing.T) {
+	for _, value := range maliciousValues {
+		// Test: Values with control characters are not written at all
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		w.Header().Set("X-Request-Id", "abc")
+		require.NoError(t, w.WriteStatusLine(OK))
+		h := GetDefaultHeaders(0)
+		h.Set("Content-Language", value)
+		err := w.WriteHeaders(h)
+		assert.ErrorIs(t, err, headers.ErrInvalidField, "%q", value)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+		assert.Nil(t, w.SentHeaders())
+
+		// Test: Valid headers can still be written afterwards
+		require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+		require.NoError(t, w.Finish())
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+	}
+
+	// Test: Invalid names are rejected too
+	h := headers.NewHeaders()
+	h.Add("X-Evil\r\nSet-Cookie", "a=1")
+	assert.ErrorIs(t, WriteHeaders(&bytes.Buffer{}, h), headers.ErrInvalidField)
+
+	// Test: Tabs and non-ASCII text are allowed
+	buf := &bytes.Buffer{}
+	h = headers.NewHeaders()
+	h.Add("X-Note", "caf\xc3\xa9\tau lait")
+	require.NoError(t, WriteHeaders(buf, h))
+	assert.Equal(t, "X-Note: caf\xc3\xa9\tau lait\r\n\r\n", buf.String())
+}
+
+func TestSetTrailerInjection(t *testing.T) {
+	for _, value := range maliciousValues {
+		// Test: Trailers are validated like headers
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		require.NoError(t, w.DeclareTrailer("X-Checksum"))
+		require.NoError(t, w.Flush())
+		buf.Reset()
+		assert.ErrorIs(t, w.SetTrailer("X-Checksum", value), headers.ErrInvalidField, "%q", value)
+		require.NoError(t, w.Finish())
+		assert.Equal(t, "0\r\n\r\n", buf.String())
+	}
+}
+
+func TestWriterOrder(t *testing.T) {
+	// Test: First body write sends an implicit 200 and default headers
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Header().Set("X-Request-Id", "abc")
+	n, err := w.Write([]byte("All good"))
+	require.NoError(t, err)
+	assert.Equal(t, 8, n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nX-Request-Id: abc\r\nContent-Type: text/plain\r\nContent-Length: 8\r\n\r\nAll good", buf.String())
+	assert.Equal(t, OK, w.Status())
+	assert.Equal(t, 8, w.BytesWritten())
+
+	// Test: Body after an explicit status only fills in the headers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(NOT_FOUND))
+	w.Header().Set("Content-Type", "text/html")
+	_, err = w.WriteBody([]byte("<p>gone</p>"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nContent-Type: text/html\r\nContent-Length: 11\r\n\r\n<p>gone</p>", buf.String())
+	assert.Equal(t, NOT_FOUND, w.Status())
+
+	// Test: A second status line is refused
+	assert.ErrorIs(t, w.WriteStatusLine(INTERNAL_SERVER_ERROR), ErrOutOfOrder)
+	assert.Equal(t, NOT_FOUND, w.Status())
+
+	// Test: Headers before the status line or twice are refused
+	w = NewWriter(&bytes.Buffer{})
+	assert.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(0)), ErrOutOfOrder)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(0)), ErrOutOfOrder)
+
+	// Test: Chunks count towards BytesWritten without their framing
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	_, err = w.WriteChunkedBody([]byte("early"))
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := headers.NewHeaders()
+	h.Add("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, 5, w.BytesWritten())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n5\r\nhello\r\n0\r\n\r\n")))
+
+	// Test: Nothing can follow the end of the response
+	_, err = w.Write([]byte("late"))
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+	_, err = w.WriteChunkedBody([]byte("late"))
+	assert.ErrorIs(t, err, ErrOutOfOrder)
+	require.NoError(t, w.Finish())
+}
+
+func TestWriterFraming(t *testing.T) {
+	// Test: Small bodies get a Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Write([]byte("hello "))
+	w.Write([]byte("world"))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\n\r\nhello world", buf.String())
+
+	// Test: Empty responses get Content-Length: 0
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", buf.String())
+
+	// Test: Bodies above the buffer size are sent chunked
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	big := bytes.Repeat([]byte("a"), bufferSize)
+	w.Write(big)
+	w.Write([]byte("b"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"1000\r\n"+string(big)+"\r\n1\r\nb\r\n0\r\n\r\n", buf.String())
+	assert.Equal(t, bufferSize+1, w.BytesWritten())
+
+	// Test: Flush switches to chunked
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.Write([]byte("tick"))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n4\r\ntick\r\n", buf.String())
+	w.Write([]byte("tock"))
+	require.NoError(t, w.Finish())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("4\r\ntick\r\n4\r\ntock\r\n0\r\n\r\n")))
+
+	// Test: Headers can be changed until the body goes out
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	w.Header().Set("Connection", "close")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", buf.String())
+
+	// Test: Declared Content-Length is streamed and enforced
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(4)))
+	_, err := w.Write([]byte("ab"))
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nab")))
+	_, err = w.Write([]byte("cde"))
+	assert.ErrorIs(t, err, ErrFraming)
+	assert.ErrorIs(t, w.Finish(), ErrFraming)
+
+	// Test: Conflicting framing headers are refused
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := GetDefaultHeaders(4)
+	h.Add("Transfer-Encoding", "chunked")
+	assert.ErrorIs(t, w.WriteHeaders(h), ErrFraming)
+}
+
+func TestTrailers(t *testing.T) {
+	// Test: Declared trailers follow the last chunk
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.DeclareTrailer("X-Checksum", "X-Length"))
+	w.Write([]byte("hello"))
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.SetTrailer("x-length", "5"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTrailer: X-Checksum, X-Length\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n0\r\nX-Checksum: abc\r\nx-length: 5\r\n\r\n", buf.String())
+
+	// Test: Trailer header set directly declares the fields too
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := headers.NewHeaders()
+	h.Add("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTrailer: X-Checksum\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nX-Checksum: abc\r\n\r\n", buf.String())
+
+	// Test: Undeclared and forbidden trailers are refused
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	assert.ErrorIs(t, w.SetTrailer("X-Other", "1"), ErrBadTrailer)
+	assert.ErrorIs(t, w.DeclareTrailer("Content-Length"), ErrBadTrailer)
+	assert.ErrorIs(t, w.DeclareTrailer("Bad Name"), ErrBadTrailer)
+	require.NoError(t, w.WriteStatusLine(OK))
+	h = headers.NewHeaders()
+	h.Add("Trailer", "Transfer-Encoding")
+	assert.ErrorIs(t, w.WriteHeaders(h), ErrBadTrailer)
+
+	// Test: Trailers cannot be declared once the headers are out or with a Content-Length
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.Flush())
+	assert.ErrorIs(t, w.DeclareTrailer("X-Checksum"), ErrOutOfOrder)
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.ErrorIs(t, w.DeclareTrailer("X-Checksum"), ErrFraming)
+
+	// Test: Trailers cannot be set after the response is finished
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	require.NoError(t, w.Finish())
+	assert.ErrorIs(t, w.SetTrailer("X-Checksum", "abc"), ErrOutOfOrder)
+}
+
+func TestWriteInformational(t *testing.T) {
+	// Test: Early hints precede the final response
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	h := headers.NewHeaders()
+	h.Add("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(EARLY_HINTS, h))
+	require.NoError(t, w.WriteInformational(CONTINUE, nil))
+	assert.False(t, w.Committed())
+	w.Write([]byte("ok"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 100 Continue\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nok", buf.String())
+
+	// Test: Only 1xx codes other than 101, and only before the status line
+	w = NewWriter(&bytes.Buffer{})
+	assert.ErrorIs(t, w.WriteInformational(OK, nil), ErrBadStatus)
+	assert.ErrorIs(t, w.WriteInformational(SWITCHING_PROTOCOLS, nil), ErrBadStatus)
+	require.NoError(t, w.WriteStatusLine(OK))
+	assert.ErrorIs(t, w.WriteInformational(EARLY_HINTS, nil), ErrOutOfOrder)
+
+	// Test: 1xx codes are not accepted as the final status
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	assert.ErrorIs(t, w.WriteStatusLine(CONTINUE), ErrBadStatus)
+	assert.ErrorIs(t, w.WriteStatusLineReason(EARLY_HINTS, "Hints"), ErrBadStatus)
+	assert.False(t, w.Committed())
+	assert.Empty(t, buf.String())
+}
+
+func TestOmitBody(t *testing.T) {
+	// Test: Held back body is dropped but its length is kept
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.OmitBody()
+	n, err := w.Write([]byte("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, 11, n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\n\r\n", buf.String())
+
+	// Test: Declared Content-Length is kept whether or not the body is written
+	for _, body := range []string{"", "video"} {
+		buf = &bytes.Buffer{}
+		w = NewWriter(buf)
+		w.OmitBody()
+		require.NoError(t, w.WriteStatusLine(OK))
+		require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+		w.Write([]byte(body))
+		require.NoError(t, w.Finish())
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+	}
+
+	// Test: Large bodies announce chunked framing without any chunks
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.OmitBody()
+	w.Write(bytes.Repeat([]byte("a"), bufferSize+1))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n", buf.String())
+}
+
+func TestDate(t *testing.T) {
+	// Test: IMF-fixdate in GMT
+	now := time.Date(1994, 11, 6, 9, 49, 37, 0, time.FixedZone("CET", 3600))
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", dateAt(now))
+
+	// Test: Value is reused within the second and renewed after it
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", dateAt(now.Add(900*time.Millisecond)))
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:38 GMT", dateAt(now.Add(time.Second)))
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: Small bodies still get a Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetHTTP10()
+	w.Write([]byte("ok"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\nok", buf.String())
+
+	// Test: Bodies of unknown length are not chunked but end with the connection
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetHTTP10()
+	require.NoError(t, w.WriteInformational(EARLY_HINTS, nil))
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	w.Write([]byte("tick"))
+	require.NoError(t, w.Flush())
+	w.Write([]byte("tock"))
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nticktock", buf.String())
+}
@@ -0,0 +1,179 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"httpserver/internal/headers"
+	"io"
+	"strconv"
+)
+
+type StatusCode int
+
+// Status codes from the IANA HTTP Status Code Registry.
+const (
+	CONTINUE            StatusCode = 100
+	SWITCHING_PROTOCOLS StatusCode = 101
+	PROCESSING          StatusCode = 102
+	EARLY_HINTS         StatusCode = 103
+
+	OK                            StatusCode = 200
+	CREATED                       StatusCode = 201
+	ACCEPTED                      StatusCode = 202
+	NON_AUTHORITATIVE_INFORMATION StatusCode = 203
+	NO_CONTENT                    StatusCode = 204
+	RESET_CONTENT                 StatusCode = 205
+	PARTIAL_CONTENT               StatusCode = 206
+	MULTI_STATUS                  StatusCode = 207
+	ALREADY_REPORTED              StatusCode = 208
+	IM_USED                       StatusCode = 226
+
+	MULTIPLE_CHOICES   StatusCode = 300
+	MOVED_PERMANENTLY  StatusCode = 301
+	FOUND              StatusCode = 302
+	SEE_OTHER          StatusCode = 303
+	NOT_MODIFIED       StatusCode = 304
+	USE_PROXY          StatusCode = 305
+	TEMPORARY_REDIRECT StatusCode = 307
+	PERMANENT_REDIRECT StatusCode = 308
+
+	BAD_REQUEST                     StatusCode = 400
+	UNAUTHORIZED                    StatusCode = 401
+	PAYMENT_REQUIRED                StatusCode = 402
+	FORBIDDEN                       StatusCode = 403
+	NOT_FOUND                       StatusCode = 404
+	METHOD_NOT_ALLOWED              StatusCode = 405
+	NOT_ACCEPTABLE                  StatusCode = 406
+	PROXY_AUTHENTICATION_REQUIRED   StatusCode = 407
+	REQUEST_TIMEOUT                 StatusCode = 408
+	CONFLICT                        StatusCode = 409
+	GONE                            StatusCode = 410
+	LENGTH_REQUIRED                 StatusCode = 411
+	PRECONDITION_FAILED             StatusCode = 412
+	CONTENT_TOO_LARGE               StatusCode = 413
+	URI_TOO_LONG                    StatusCode = 414
+	UNSUPPORTED_MEDIA_TYPE          StatusCode = 415
+	RANGE_NOT_SATISFIABLE           StatusCode = 416
+	EXPECTATION_FAILED              StatusCode = 417
+	MISDIRECTED_REQUEST             StatusCode = 421
+	UNPROCESSABLE_CONTENT           StatusCode = 422
+	LOCKED                          StatusCode = 423
+	FAILED_DEPENDENCY               StatusCode = 424
+	TOO_EARLY                       StatusCode = 425
+	UPGRADE_REQUIRED                StatusCode = 426
+	PRECONDITION_REQUIRED           StatusCode = 428
+	TOO_MANY_REQUESTS               StatusCode = 429
+	REQUEST_HEADER_FIELDS_TOO_LARGE StatusCode = 431
+	UNAVAILABLE_FOR_LEGAL_REASONS   StatusCode = 451
+
+	INTERNAL_SERVER_ERROR           StatusCode = 500
+	NOT_IMPLEMENTED                 StatusCode = 501
+	BAD_GATEWAY                     StatusCode = 502
+	SERVICE_UNAVAILABLE             StatusCode = 503
+	GATEWAY_TIMEOUT                 StatusCode = 504
+	HTTP_VERSION_NOT_SUPPORTED      StatusCode = 505
+	VARIANT_ALSO_NEGOTIATES         StatusCode = 506
+	INSUFFICIENT_STORAGE            StatusCode = 507
+	LOOP_DETECTED                   StatusCode = 508
+	NOT_EXTENDED                    StatusCode = 510
+	NETWORK_AUTHENTICATION_REQUIRED StatusCode = 511
+)
+
+var reasonPhrases = map[StatusCode]string{
+	CONTINUE:            "Continue",
+	SWITCHING_PROTOCOLS: "Switching Protocols",
+	PROCESSING:          "Processing",
+	EARLY_HINTS:         "Early Hints",
+
+	OK:                            "OK",
+	CREATED:                       "Created",
+	ACCEPTED:                      "Accepted",
+	NON_AUTHORITATIVE_INFORMATION: "Non-Authoritative Information",
+	NO_CONTENT:                    "No Content",
+	RESET_CONTENT:                 "Reset Content",
+	PARTIAL_CONTENT:               "Partial Content",
+	MULTI_STATUS:                  "Multi-Status",
+	ALREADY_REPORTED:              "Already Reported",
+	IM_USED:                       "IM Used",
+
+	MULTIPLE_CHOICES:   "Multiple Choices",
+	MOVED_PERMANENTLY:  "Moved Permanently",
+	FOUND:              "Found",
+	SEE_OTHER:          "See Other",
+	NOT_MODIFIED:       "Not Modified",
+	USE_PROXY:          "Use Proxy",
+	TEMPORARY_REDIRECT: "Temporary Redirect",
+	PERMANENT_REDIRECT: "Permanent Redirect",
+
+	BAD_REQUEST:                     "Bad Request",
+	UNAUTHORIZED:                    "Unauthorized",
+	PAYMENT_REQUIRED:                "Payment Required",
+	FORBIDDEN:                       "Forbidden",
+	NOT_FOUND:                       "Not Found",
+	METHOD_NOT_ALLOWED:              "Method Not Allowed",
+	NOT_ACCEPTABLE:                  "Not Acceptable",
+	PROXY_AUTHENTICATION_REQUIRED:   "Proxy Authentication Required",
+	REQUEST_TIMEOUT:                 "Request Timeout",
+	CONFLICT:                        "Conflict",
+	GONE:                            "Gone",
+	LENGTH_REQUIRED:                 "Length Required",
+	PRECONDITION_FAILED:             "Precondition Failed",
+	CONTENT_TOO_LARGE:               "Content Too Large",
+	URI_TOO_LONG:                    "URI Too Long",
+	UNSUPPORTED_MEDIA_TYPE:          "Unsupported Media Type",
+	RANGE_NOT_SATISFIABLE:           "Range Not Satisfiable",
+	EXPECTATION_FAILED:              "Expectation Failed",
+	MISDIRECTED_REQUEST:             "Misdirected Request",
+	UNPROCESSABLE_CONTENT:           "Unprocessable Content",
+	LOCKED:                          "Locked",
+	FAILED_DEPENDENCY:               "Failed Dependency",
+	TOO_EARLY:                       "Too Early",
+	UPGRADE_REQUIRED:                "Upgrade Required",
+	PRECONDITION_REQUIRED:           "Precondition Required",
+	TOO_MANY_REQUESTS:               "Too Many Requests",
+	REQUEST_HEADER_FIELDS_TOO_LARGE: "Request Header Fields Too Large",
+	UNAVAILABLE_FOR_LEGAL_REASONS:   "Unavailable For Legal Reasons",
+
+	INTERNAL_SERVER_ERROR:           "Internal Server Error",
+	NOT_IMPLEMENTED:                 "Not Implemented",
+	BAD_GATEWAY:                     "Bad Gateway",
+	SERVICE_UNAVAILABLE:             "Service Unavailable",
+	GATEWAY_TIMEOUT:                 "Gateway Timeout",
+	HTTP_VERSION_NOT_SUPPORTED:      "HTTP Version Not Supported",
+	VARIANT_ALSO_NEGOTIATES:         "Variant Also Negotiates",
+	INSUFFICIENT_STORAGE:            "Insufficient Storage",
+	LOOP_DETECTED:                   "Loop Detected",
+	NOT_EXTENDED:                    "Not Extended",
+	NETWORK_AUTHENTICATION_REQUIRED: "Network Authentication Required",
+}
+
+var ErrBadStatus = errors.New("invalid status line")
+
+// StatusText returns the standard reason phrase for statusCode, or "" for
+// codes that are not registered.
+func StatusText(statusCode StatusCode) string {
+	return reasonPhrases[statusCode]
+}
+
+// BodyAllowed reports whether a response with statusCode can have a body.
+// 1xx, 204 and 304 responses never do.
+func BodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != NO_CONTENT && statusCode != NOT_MODIFIED
+}
+
+// WriteStatusLine writes the status line with the standard reason phrase.
+// Unregistered codes get an empty one, which RFC 9112 allows.
+func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
+	return writeStatusLine(w, statusCode, StatusText(statusCode))
+}
+
+func writeStatusLine(w io.Writer, statusCode StatusCode, reason string) error {
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("%w: status code %d", ErrBadStatus, statusCode)
+	}
+	if !headers.ValidValue(reason) {
+		return fmt.Errorf("%w: reason phrase %q", ErrBadStatus, reason)
+	}
+	_, err := w.Write([]byte("HTTP/1.1 " + strconv.Itoa(int(statusCode)) + " " + reason + "\r\n"))
+	return err
+}
@@ -0,0 +1,71 @@
+package response
+
+import (
+	"bytes"
+	"httpserver/internal/headers"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusText(t *testing.T) {
+	// Test: Registered codes have their standard reason phrase
+	assert.Equal(t, "No Content", StatusText(NO_CONTENT))
+	assert.Equal(t, "Too Many Requests", StatusText(TOO_MANY_REQUESTS))
+	assert.Equal(t, "Early Hints", StatusText(EARLY_HINTS))
+	assert.Equal(t, "Network Authentication Required", StatusText(NETWORK_AUTHENTICATION_REQUIRED))
+
+	// Test: Unregistered codes get an empty reason phrase
+	assert.Equal(t, "", StatusText(299))
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteStatusLine(buf, 299))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: Codes outside three digits are refused
+	assert.ErrorIs(t, WriteStatusLine(&bytes.Buffer{}, 42), ErrBadStatus)
+	assert.ErrorIs(t, WriteStatusLine(&bytes.Buffer{}, 1000), ErrBadStatus)
+}
+
+func TestStatusLineReason(t *testing.T) {
+	// Test: Custom reason phrase
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLineReason(TOO_MANY_REQUESTS, "Slow Down"))
+	assert.Equal(t, "HTTP/1.1 429 Slow Down\r\n", buf.String())
+	assert.Equal(t, TOO_MANY_REQUESTS, w.Status())
+
+	// Test: Reason phrases cannot split the response
+	w = NewWriter(&bytes.Buffer{})
+	assert.ErrorIs(t, w.WriteStatusLineReason(OK, "OK\r\nSet-Cookie: a=1"), ErrBadStatus)
+	assert.Equal(t, StatusCode(0), w.Status())
+}
+
+func TestBodylessStatus(t *testing.T) {
+	for code, want := range map[StatusCode]string{
+		NO_CONTENT:   "HTTP/1.1 204 No Content\r\n\r\n",
+		NOT_MODIFIED: "HTTP/1.1 304 Not Modified\r\n\r\n",
+	} {
+		// Test: No body and no framing fields are sent
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		require.NoError(t, w.WriteStatusLine(code))
+		require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+		_, err := w.Write([]byte("body"))
+		assert.ErrorIs(t, err, ErrFraming)
+		require.NoError(t, w.Flush())
+		require.NoError(t, w.Finish())
+		assert.Equal(t, want, buf.String())
+	}
+
+	// Test: 204 refuses framing headers, 304 keeps a Content-Length
+	w := NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(NO_CONTENT))
+	assert.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(0)), ErrFraming)
+	buf := &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(NOT_MODIFIED))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(1234)))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\nContent-Length: 1234\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+}
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"httpserver/internal/server"
+	"slices"
+	"strings"
+)
+
+// Router dispatches requests by method and path pattern. Patterns are made
+// of static segments, {name} segments that match exactly one segment and
+// an optional trailing *name segment that matches the rest of the path.
+type Router struct {
+	root       *node
+	middleware []server.Middleware
+	NotFound   server.Handler
+	// RenderError writes the 404 and 405 responses. The default is
+	// server.RenderError, the renderer the server was configured with.
+	RenderError server.ErrorRenderer
+}
+
+type node struct {
+	static   map[string]*node
+	param    *node
+	name     string
+	wildcard *node
+	handlers map[string]server.Handler
+}
+
+func New() *Router {
+	return &Router{root: &node{}, RenderError: server.RenderError}
+}
+
+// Use adds middleware that runs for every request the router sees,
+// including those answered with 404 or 405.
+func (r *Router) Use(middleware ...server.Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Handle registers h for method and pattern, wrapped in the given
+// route-specific middleware. It panics on malformed or duplicate patterns.
+func (r *Router) Handle(method, pattern string, h server.Handler, middleware ...server.Middleware) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("router: pattern must start with '/': " + pattern)
+	}
+	n := r.root
+	segments := splitPath(pattern)
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			name := seg[1 : len(seg)-1]
+			if n.param == nil {
+				n.param = &node{name: name}
+			} else if n.param.name != name {
+				panic("router: conflicting parameter names in " + pattern)
+			}
+			n = n.param
+		case strings.HasPrefix(seg, "*"):
+			if i != len(segments)-1 {
+				panic("router: wildcard must be the last segment in " + pattern)
+			}
+			if n.wildcard == nil {
+				n.wildcard = &node{name: seg[1:]}
+			} else if n.wildcard.name != seg[1:] {
+				panic("router: conflicting wildcard names in " + pattern)
+			}
+			n = n.wildcard
+		default:
+			if n.static == nil {
+				n.static = make(map[string]*node)
+			}
+			child, ok := n.static[seg]
+			if !ok {
+				child = &node{}
+				n.static[seg] = child
+			}
+			n = child
+		}
+	}
+	if n.handlers == nil {
+		n.handlers = make(map[string]server.Handler)
+	}
+	if _, ok := n.handlers[method]; ok {
+		panic("router: duplicate route " + method + " " + pattern)
+	}
+	n.handlers[method] = server.Chain(h, middleware...)
+}
+
+func (r *Router) Handler() server.Handler {
+	return server.Chain(r.serve, r.middleware...)
+}
+
+func (r *Router) serve(w *response.Writer, req *request.Request) {
+	params := map[string]string{}
+	n := r.root.match(splitPath(req.URL.Path), params)
+	if n == nil {
+		if r.NotFound != nil {
+			r.NotFound(w, req)
+			return
+		}
+		r.RenderError(w, req, response.NOT_FOUND, "")
+		return
+	}
+
+	req.Params = params
+	if h, ok := n.handler(req.RequestLine.Method); ok {
+		h(w, req)
+		return
+	}
+	w.Header().Set("Allow", n.allow())
+	if req.RequestLine.Method == "OPTIONS" {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		return
+	}
+	r.RenderError(w, req, response.METHOD_NOT_ALLOWED, "")
+}
+
+// match prefers static segments over parameters and parameters over
+// wildcards, backtracking when a more specific branch has no route.
+func (n *node) match(segments []string, params map[string]string) *node {
+	if len(segments) == 0 {
+		if n.handlers != nil {
+			return n
+		}
+		if n.wildcard != nil {
+			params[n.wildcard.name] = ""
+			return n.wildcard
+		}
+		return nil
+	}
+	seg, rest := segments[0], segments[1:]
+	if child, ok := n.static[seg]; ok {
+		if found := child.match(rest, params); found != nil {
+			return found
+		}
+	}
+	if n.param != nil && seg != "" {
+		if found := n.param.match(rest, params); found != nil {
+			params[n.param.name] = seg
+			return found
+		}
+	}
+	if n.wildcard != nil {
+		params[n.wildcard.name] = strings.Join(segments, "/")
+		return n.wildcard
+	}
+	return nil
+}
+
+// handler returns the handler for method. HEAD falls back to GET, the
+// server drops the body of the response.
+func (n *node) handler(method string) (server.Handler, bool) {
+	h, ok := n.handlers[method]
+	if !ok && method == "HEAD" {
+		h, ok = n.handlers["GET"]
+	}
+	return h, ok
+}
+
+func (n *node) allow() string {
+	methods := make([]string, 0, len(n.handlers)+2)
+	for method := range n.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := n.handlers["HEAD"]; !ok && n.handlers["GET"] != nil {
+		methods = append(methods, "HEAD")
+	}
+	if _, ok := n.handlers["OPTIONS"]; !ok {
+		methods = append(methods, "OPTIONS")
+	}
+	slices.Sort(methods)
+	return strings.Join(methods, ", ")
+}
+
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"bytes"
+	"httpserver/internal/headers"
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"httpserver/internal/server"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(r *Router, method, target string) string {
+	buf := &bytes.Buffer{}
+	u, err := request.ParseTarget(method, target)
+	if err != nil {
+		panic(err)
+	}
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		URL:         u,
+		Headers:     headers.NewHeaders(),
+	}
+	w := response.NewWriter(buf)
+	r.Handler()(w, req)
+	w.Finish()
+	return buf.String()
+}
+
+func reply(text string) func(*response.Writer, *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := []byte(text)
+		for _, name := range []string{"id", "file", "path"} {
+			if v, ok := req.Params[name]; ok {
+				body = append(body, " "+name+"="+v...)
+			}
+		}
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func body(raw string) string {
+	_, b, _ := strings.Cut(raw, "\r\n\r\n")
+	return b
+}
+
+func TestRouting(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/", reply("root"))
+	r.Handle("GET", "/users", reply("list"))
+	r.Handle("POST", "/users", reply("create"))
+	r.Handle("GET", "/users/me", reply("me"))
+	r.Handle("GET", "/users/{id}", reply("user"))
+	r.Handle("GET", "/users/{id}/files/{file}", reply("file"))
+	r.Handle("GET", "/static/*path", reply("static"))
+
+	// Test: Static routes by method
+	assert.Equal(t, "root", body(serve(r, "GET", "/")))
+	assert.Equal(t, "list", body(serve(r, "GET", "/users")))
+	assert.Equal(t, "create", body(serve(r, "POST", "/users")))
+
+	// Test: Query string is ignored for matching
+	assert.Equal(t, "list", body(serve(r, "GET", "/users?page=2")))
+
+	// Test: Percent-encoded paths match their decoded routes
+	assert.Equal(t, "user id=jane doe", body(serve(r, "GET", "/users/jane%20doe")))
+	assert.Equal(t, "list", body(serve(r, "GET", "/%75sers")))
+
+	// Test: Absolute-form targets are routed by their path
+	assert.Equal(t, "list", body(serve(r, "GET", "http://example.com/users?page=2")))
+
+	// Test: Static segments win over parameters
+	assert.Equal(t, "me", body(serve(r, "GET", "/users/me")))
+
+	// Test: Path parameters
+	assert.Equal(t, "user id=42", body(serve(r, "GET", "/users/42")))
+	assert.Equal(t, "file id=me file=a.txt", body(serve(r, "GET", "/users/me/files/a.txt")))
+
+	// Test: Wildcards capture the rest of the path
+	assert.Equal(t, "static path=css/site.css", body(serve(r, "GET", "/static/css/site.css")))
+	assert.Equal(t, "static path=", body(serve(r, "GET", "/static/")))
+
+	// Test: Unknown paths get 404
+	res := serve(r, "GET", "/nope")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	res = serve(r, "GET", "/users/42/other")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Known path with another method gets 405 and Allow
+	res = serve(r, "DELETE", "/users")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, res, "Allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: HEAD is served by the GET handler
+	assert.Equal(t, "list", body(serve(r, "HEAD", "/users")))
+	res = serve(r, "HEAD", "/static/")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: OPTIONS is answered automatically
+	res = serve(r, "OPTIONS", "/users/7")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, res, "Allow: GET, HEAD, OPTIONS\r\n")
+
+	// Test: Custom NotFound handler
+	r.NotFound = reply("custom")
+	assert.Equal(t, "custom", body(serve(r, "GET", "/nope")))
+}
+
+func TestMiddleware(t *testing.T) {
+	tag := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				w.Header().Set("X-Seen", name)
+				next(w, req)
+			}
+		}
+	}
+	r := New()
+	r.Use(tag("global"))
+	r.Handle("GET", "/plain", reply("plain"))
+	r.Handle("GET", "/tagged", reply("tagged"), tag("route"))
+
+	// Test: Global middleware runs for every route
+	assert.Contains(t, serve(r, "GET", "/plain"), "X-Seen: global\r\n")
+
+	// Test: Route middleware runs after global middleware
+	assert.Contains(t, serve(r, "GET", "/tagged"), "X-Seen: global, route\r\n")
+
+	// Test: Global middleware also sees unmatched requests
+	assert.Contains(t, serve(r, "GET", "/missing"), "X-Seen: global\r\n")
+}
+
+func TestHandlePanics(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/users/{id}", reply("user"))
+
+	// Test: Duplicate route
+	assert.Panics(t, func() { r.Handle("GET", "/users/{id}", reply("again")) })
+
+	// Test: Conflicting parameter name
+	assert.Panics(t, func() { r.Handle("POST", "/users/{name}", reply("user")) })
+
+	// Test: Wildcard not last
+	assert.Panics(t, func() { r.Handle("GET", "/static/*path/more", reply("static")) })
+
+	// Test: Pattern without leading slash
+	assert.Panics(t, func() { r.Handle("GET", "users", reply("users")) })
+}
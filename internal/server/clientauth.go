@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"os"
+	"strings"
+)
+
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		pemBytes, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, errors.New("no certificates found in " + f)
+		}
+	}
+	return pool, nil
+}
+
+// ClientCertAuthorizer only lets a request through when the common name of
+// its verified client certificate is allowed one of the path prefixes in
+// allowed. Prefixes are matched against the cleaned URL.Path that the router
+// dispatches on. Everything else gets a 403 rendered with render, or with
+// RenderError when render is nil.
+func ClientCertAuthorizer(allowed map[string][]string, render ErrorRenderer) Middleware {
+	if render == nil {
+		render = RenderError
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			cert := req.TLS.ClientCertificate()
+			if cert == nil {
+				render(w, req, response.FORBIDDEN, "")
+				return
+			}
+			for _, prefix := range allowed[cert.Subject.CommonName] {
+				if req.URL != nil && hasPathPrefix(req.URL.Path, prefix) {
+					next(w, req)
+					return
+				}
+			}
+			render(w, req, response.FORBIDDEN, "")
+		}
+	}
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
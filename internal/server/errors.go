@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html"
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ErrorRenderer writes a complete error response. req is nil when the
+// request could not be parsed.
+type ErrorRenderer func(w *response.Writer, req *request.Request, code response.StatusCode, message string)
+
+// HandleErrors adapts h to a Handler. A returned *HandlerError is rendered
+// with its code and message, an error from reading the request body with
+// the status the server uses for it, and any other error as a 500 that does
+// not reveal the error text. A nil render means RenderError. Server
+// errors are logged to l, or to the standard logger when l is nil; pass the
+// server's WithErrorLog logger to keep them in one place.
+func HandleErrors(h ErrHandler, render ErrorRenderer, l *log.Logger) Handler {
+	if render == nil {
+		render = RenderError
+	}
+	if l == nil {
+		l = log.Default()
+	}
+	return func(w *response.Writer, req *request.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+		code, message := errorResponse(err)
+		// Failing to read the request body is the client's error.
+		if bodyErr := req.BodyError(); bodyErr != nil && errors.Is(err, bodyErr) {
+			if c, ok := statusForError(bodyErr); ok {
+				code, message = c, errorMessage(c, bodyErr)
+			}
+		}
+		if w.Committed() {
+			l.Printf("Error after response started for %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+			return
+		}
+		if code >= 500 {
+			l.Printf("Error serving %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		}
+		render(w, req, code, message)
+	}
+}
+
+func errorResponse(err error) (response.StatusCode, string) {
+	var herr *HandlerError
+	if !errors.As(err, &herr) {
+		return response.INTERNAL_SERVER_ERROR, ""
+	}
+	if herr.Code == 0 {
+		return response.INTERNAL_SERVER_ERROR, herr.Message
+	}
+	return herr.Code, herr.Message
+}
+
+// RenderError renders with the renderer set with WithErrorRenderer on the
+// server serving w, or with RenderNegotiated outside a server.
+func RenderError(w *response.Writer, req *request.Request, code response.StatusCode, message string) {
+	if !w.RenderError(code, message) {
+		RenderNegotiated(w, req, code, message)
+	}
+}
+
+// RenderNegotiated picks HTML, JSON or plain text based on the request's
+// Accept header, preferring HTML when the client accepts anything.
+func RenderNegotiated(w *response.Writer, req *request.Request, code response.StatusCode, message string) {
+	if req == nil {
+		RenderText(w, req, code, message)
+		return
+	}
+	accept, ok := req.Headers.Get("Accept")
+	if !ok {
+		RenderHTML(w, req, code, message)
+		return
+	}
+	switch negotiate(accept, "text/html", "application/json", "text/plain") {
+	case "application/json":
+		RenderJSON(w, req, code, message)
+	case "text/plain":
+		RenderText(w, req, code, message)
+	default:
+		RenderHTML(w, req, code, message)
+	}
+}
+
+func RenderText(w *response.Writer, _ *request.Request, code response.StatusCode, message string) {
+	body := strconv.Itoa(int(code)) + " " + response.StatusText(code) + "\n"
+	if message != "" {
+		body += message + "\n"
+	}
+	writeErrorBody(w, code, "text/plain", []byte(body))
+}
+
+func RenderHTML(w *response.Writer, _ *request.Request, code response.StatusCode, message string) {
+	title := html.EscapeString(response.StatusText(code))
+	if message == "" {
+		message = response.StatusText(code)
+	}
+	body := []byte(`
+	<html>
+		<head>
+			<title>` + strconv.Itoa(int(code)) + " " + title + `</title>
+		</head>
+		<body>
+			<h1>` + title + `</h1>
+			<p>` + html.EscapeString(message) + `</p>
+		</body>
+	</html>`)
+	writeErrorBody(w, code, "text/html", body)
+}
+
+func RenderJSON(w *response.Writer, _ *request.Request, code response.StatusCode, message string) {
+	if message == "" {
+		message = response.StatusText(code)
+	}
+	body := &bytes.Buffer{}
+	enc := json.NewEncoder(body)
+	enc.SetEscapeHTML(false)
+	enc.Encode(struct {
+		Code    int    `json:"code"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{int(code), response.StatusText(code), message})
+	writeErrorBody(w, code, "application/json", body.Bytes())
+}
+
+func writeErrorBody(w *response.Writer, code response.StatusCode, contentType string, body []byte) {
+	h := response.GetDefaultHeaders(len(body))
+	h.Overwrite("Content-Type", contentType)
+	w.WriteStatusLine(code)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+// negotiate returns the offer with the highest quality in accept, earlier
+// offers winning ties, or "" when none is acceptable.
+func negotiate(accept string, offers ...string) string {
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q := acceptQuality(accept, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+func acceptQuality(accept, offer string) float64 {
+	offerType, _, _ := strings.Cut(offer, "/")
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+		s := -1
+		switch {
+		case mediaRange == offer:
+			s = 2
+		case mediaRange == offerType+"/*":
+			s = 1
+		case mediaRange == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, quality = s, 1
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+	}
+	return quality
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"httpserver/internal/headers"
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runErrHandler(h ErrHandler, render ErrorRenderer, accept string) string {
+	buf := &bytes.Buffer{}
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	}
+	if accept != "" {
+		req.Headers.Set("Accept", accept)
+	}
+	HandleErrors(h, render, log.New(io.Discard, "", 0))(response.NewWriter(buf), req)
+	return buf.String()
+}
+
+func failWith(err error) ErrHandler {
+	return func(*response.Writer, *request.Request) error { return err }
+}
+
+func TestHandleErrors(t *testing.T) {
+	notFound := &HandlerError{Code: response.NOT_FOUND, Message: "no such <thing>"}
+
+	// Test: HandlerError is rendered with its code
+	res := runErrHandler(failWith(notFound), RenderText, "")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n404 Not Found\nno such <thing>\n"))
+
+	// Test: Wrapped HandlerError keeps its code
+	res = runErrHandler(failWith(fmt.Errorf("lookup: %w", notFound)), RenderText, "")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n404 Not Found\nno such <thing>\n"))
+
+	// Test: Other errors become a 500 without leaking the error text
+	res = runErrHandler(failWith(errors.New("db password is hunter2")), RenderText, "")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.NotContains(t, res, "hunter2")
+
+	// Test: Server errors are logged to the given logger
+	logs := &bytes.Buffer{}
+	req := &request.Request{RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/x", HttpVersion: "1.1"}}
+	HandleErrors(failWith(errors.New("disk full")), RenderText, log.New(logs, "", 0))(response.NewWriter(io.Discard), req)
+	assert.Equal(t, "Error serving GET /x: disk full\n", logs.String())
+
+	// Test: Nil error leaves the response alone
+	res = runErrHandler(func(w *response.Writer, _ *request.Request) error {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		return nil
+	}, nil, "")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Error after the response started is not rendered
+	res = runErrHandler(func(w *response.Writer, _ *request.Request) error {
+		w.WriteStatusLine(response.OK)
+		return notFound
+	}, nil, "")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", res)
+
+	// Test: HTML rendering escapes the message
+	res = runErrHandler(failWith(notFound), nil, "text/html")
+	assert.Contains(t, res, "Content-Type: text/html\r\n")
+	assert.Contains(t, res, "<title>404 Not Found</title>")
+	assert.Contains(t, res, "<p>no such &lt;thing&gt;</p>")
+
+	// Test: JSON rendering
+	res = runErrHandler(failWith(notFound), nil, "application/json")
+	assert.Contains(t, res, "Content-Type: application/json\r\n")
+	assert.True(t, strings.HasSuffix(res, `{"code":404,"status":"Not Found","message":"no such <thing>"}`+"\n"))
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/html", "application/json", "text/plain"}
+
+	// Test: Exact match
+	assert.Equal(t, "application/json", negotiate("application/json", offers...))
+
+	// Test: Wildcards prefer the first offer
+	assert.Equal(t, "text/html", negotiate("*/*", offers...))
+	assert.Equal(t, "text/html", negotiate("text/*", offers...))
+
+	// Test: Quality values
+	assert.Equal(t, "application/json", negotiate("text/html;q=0.5, application/json", offers...))
+	assert.Equal(t, "text/plain", negotiate("text/*;q=0.8, text/html;q=0.1, */*;q=0.2", offers...))
+
+	// Test: More specific range overrides a wildcard
+	assert.Equal(t, "application/json", negotiate("*/*, text/html;q=0", offers...))
+
+	// Test: Nothing acceptable
+	assert.Equal(t, "", negotiate("image/png", offers...))
+}
+
+func TestServerErrorRenderer(t *testing.T) {
+	notFound := &HandlerError{Code: response.NOT_FOUND, Message: "gone"}
+	authorize := ClientCertAuthorizer(map[string][]string{}, nil)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/private" {
+			authorize(HandleErrors(failWith(notFound), nil, nil))(w, req)
+			return
+		}
+		HandleErrors(failWith(notFound), nil, nil)(w, req)
+	}, WithErrorRenderer(RenderJSON))
+	get := func(target string) string {
+		conn := dial(t, s)
+		_, err := conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\nAccept: text/html\r\n\r\n"))
+		require.NoError(t, err)
+		status, h, body := readResponse(t, bufio.NewReader(conn))
+		return status + "|" + h["content-type"] + "|" + body
+	}
+
+	// Test: HandleErrors without a renderer uses the server's
+	assert.Equal(t, "HTTP/1.1 404 Not Found|application/json|"+`{"code":404,"status":"Not Found","message":"gone"}`+"\n", get("/"))
+
+	// Test: ClientCertAuthorizer without a renderer uses the server's
+	assert.Equal(t, "HTTP/1.1 403 Forbidden|application/json|"+`{"code":403,"status":"Forbidden","message":"Forbidden"}`+"\n", get("/private"))
+}
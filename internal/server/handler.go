@@ -3,10 +3,30 @@ package server
 import (
 	"httpserver/internal/request"
 	"httpserver/internal/response"
+	"strconv"
 )
 
 type HandlerError struct {
 	Message string
 	Code    response.StatusCode
 }
+
+func (e *HandlerError) Error() string {
+	return strconv.Itoa(int(e.Code)) + " " + e.Message
+}
+
 type Handler func(w *response.Writer, req *request.Request)
+
+// ErrHandler is a Handler that reports failure by returning an error
+// instead of writing the error response itself, see HandleErrors.
+type ErrHandler func(w *response.Writer, req *request.Request) error
+
+type Middleware func(Handler) Handler
+
+// Chain wraps h in middleware so that the first one listed runs first.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
@@ -1,31 +1,229 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"httpserver/internal/headers"
 	"httpserver/internal/request"
 	"httpserver/internal/response"
+	"io"
 	"log"
 	"net"
+	"runtime/debug"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Server struct {
-	listener net.Listener
-	closed   atomic.Bool
-	handler  Handler
+	listener           net.Listener
+	closed             atomic.Bool
+	handler            Handler
+	address            string
+	maxRequestsPerConn int
+	readHeaderTimeout  time.Duration
+	readTimeout        time.Duration
+	writeTimeout       time.Duration
+	idleTimeout        time.Duration
+	maxHeaderBytes     int
+	maxBodyBytes       int
+	clientCAs          *x509.CertPool
+	clientAuth         tls.ClientAuthType
+	errorLog           *log.Logger
+	panicHook          func(req *request.Request, v any, stack []byte)
+	renderError        ErrorRenderer
+	serverName         string
+
+	mu    sync.Mutex
+	conns map[net.Conn]connState
+}
+
+type connState int
+
+const (
+	connStateIdle connState = iota
+	connStateActive
+)
+
+const (
+	shutdownPollInterval  = 50 * time.Millisecond
+	DefaultMaxHeaderBytes = 16 << 10
+	DefaultServerName     = "httpserver"
+	// maxDiscardBytes is how much unread request body is skipped to keep a
+	// connection alive. Larger leftovers close the connection instead.
+	maxDiscardBytes = 256 << 10
+)
+
+// readerPool recycles the per-connection read buffers.
+var readerPool = sync.Pool{
+	New: func() any { return bufio.NewReader(nil) },
+}
+
+type Option func(*Server)
+
+// WithMaxRequestsPerConn closes a persistent connection after n requests.
+// Zero, the default, means no limit.
+func WithMaxRequestsPerConn(n int) Option {
+	return func(s *Server) {
+		s.maxRequestsPerConn = n
+	}
+}
+
+// WithAddress sets the host the server binds to. The default is every
+// interface.
+func WithAddress(host string) Option {
+	return func(s *Server) {
+		s.address = host
+	}
+}
+
+// WithReadHeaderTimeout bounds the time from the first byte of a request
+// until its header section has been received. When it is zero the read
+// timeout applies.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readHeaderTimeout = d
+	}
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	s := &Server{handler: handler}
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+// WithReadTimeout bounds the time from the first byte of a request until
+// its body has been read.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readTimeout = d
+	}
+}
+
+// WithWriteTimeout bounds the time a handler has to write its response.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout = d
+	}
+}
+
+// WithIdleTimeout bounds how long a keep-alive connection waits for the
+// next request. When unset the read timeout is used.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithMaxHeaderBytes limits the size of the request line and headers. A
+// request line over the limit gets 414, a header section over it 431.
+func WithMaxHeaderBytes(n int) Option {
+	return func(s *Server) {
+		s.maxHeaderBytes = n
+	}
+}
+
+// WithMaxBodyBytes limits the size of request bodies. Zero, the default,
+// means no limit.
+func WithMaxBodyBytes(n int) Option {
+	return func(s *Server) {
+		s.maxBodyBytes = n
+	}
+}
+
+// WithClientAuth asks TLS clients for a certificate signed by one of the
+// CAs in pool. mode is usually tls.RequireAndVerifyClientCert or
+// tls.VerifyClientCertIfGiven.
+func WithClientAuth(pool *x509.CertPool, mode tls.ClientAuthType) Option {
+	return func(s *Server) {
+		s.clientCAs = pool
+		s.clientAuth = mode
+	}
+}
+
+// WithMiddleware wraps the server's handler, see Chain.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(s *Server) {
+		s.handler = Chain(s.handler, middleware...)
+	}
+}
+
+// WithErrorLog sends connection errors and recovered panics to l instead
+// of the standard logger.
+func WithErrorLog(l *log.Logger) Option {
+	return func(s *Server) {
+		s.errorLog = l
+	}
+}
+
+// WithPanicHook calls f with the value and stack trace of every panic
+// recovered from the handler.
+func WithPanicHook(f func(req *request.Request, v any, stack []byte)) Option {
+	return func(s *Server) {
+		s.panicHook = f
+	}
+}
+
+// WithErrorRenderer sets how the server renders the error responses it
+// sends itself, such as 400s for malformed requests and 500s for panics,
+// and the error responses of handlers that render with RenderError. The
+// default is RenderNegotiated.
+func WithErrorRenderer(render ErrorRenderer) Option {
+	return func(s *Server) {
+		s.renderError = render
+	}
+}
+
+// WithServerName sets the Server header added to responses whose handler
+// did not set one. An empty name leaves it out. The default is
+// DefaultServerName.
+func WithServerName(name string) Option {
+	return func(s *Server) {
+		s.serverName = name
+	}
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	return serve(port, handler, nil, opts)
+}
+
+// ServeTLS is like Serve but terminates TLS on every connection, picking
+// the certificate from certs by SNI.
+func ServeTLS(port int, handler Handler, certs *CertStore, opts ...Option) (*Server, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	return serve(port, handler, config, opts)
+}
+
+func serve(port int, handler Handler, tlsConfig *tls.Config, opts []Option) (*Server, error) {
+	s := &Server{
+		handler:        handler,
+		maxHeaderBytes: DefaultMaxHeaderBytes,
+		renderError:    RenderNegotiated,
+		serverName:     DefaultServerName,
+		conns:          make(map[net.Conn]connState),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.address, strconv.Itoa(port)))
 	if err != nil {
 		return nil, err
 	}
+	if tlsConfig != nil {
+		tlsConfig.ClientCAs = s.clientCAs
+		tlsConfig.ClientAuth = s.clientAuth
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 	s.listener = listener
 	go s.listen()
 	return s, nil
 }
 
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 func (s *Server) Close() error {
 	if s.closed.Load() {
 		return nil
@@ -35,6 +233,60 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// Shutdown stops accepting connections, closes idle keep-alive connections
+// and waits for active ones to finish their current request. When ctx
+// expires first, the remaining connections are closed and ctx.Err() is
+// returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Close()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.closeAllConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if state == connStateIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) closeAllConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) setConnState(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = state
+}
+
+func (s *Server) forgetConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
@@ -42,21 +294,258 @@ func (s *Server) listen() {
 			if s.closed.Load() {
 				return
 			}
-			log.Printf("Error accepting connection: %v", err)
+			s.logf("Error accepting connection: %v", err)
 			continue
 		}
+		s.setConnState(conn, connStateIdle)
 		go s.handle(conn)
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
+	defer s.forgetConn(conn)
 	defer conn.Close()
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		log.Printf("Error reading request: %v", err)
-		return
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(conn)
+	defer func() {
+		reader.Reset(nil)
+		readerPool.Put(reader)
+	}()
+	limits := request.Limits{MaxHeaderBytes: s.maxHeaderBytes, MaxBodyBytes: s.maxBodyBytes}
+	var tlsInfo *request.TLSInfo
+	for served := 1; ; served++ {
+		s.setConnState(conn, connStateIdle)
+		if s.closed.Load() {
+			return
+		}
+		idleTimeout := s.idleTimeout
+		if idleTimeout == 0 {
+			idleTimeout = s.readTimeout
+		}
+		conn.SetReadDeadline(deadline(time.Now(), idleTimeout))
+		if _, err := reader.Peek(1); err != nil {
+			return
+		}
+		s.setConnState(conn, connStateActive)
+		if tlsConn, ok := conn.(*tls.Conn); ok && tlsInfo == nil {
+			tlsInfo = request.NewTLSInfo(tlsConn.ConnectionState())
+		}
+
+		start := time.Now()
+		headerTimeout := s.readHeaderTimeout
+		if headerTimeout == 0 {
+			headerTimeout = s.readTimeout
+		}
+		conn.SetReadDeadline(deadline(start, headerTimeout))
+		req, err := request.RequestFromReaderWithLimits(reader, limits)
+		if err != nil {
+			s.rejectRequest(conn, err)
+			return
+		}
+		req.TLS = tlsInfo
+		// The handler reads the body from the connection as it goes.
+		conn.SetReadDeadline(deadline(start, s.readTimeout))
+
+		conn.SetWriteDeadline(deadline(time.Now(), s.writeTimeout))
+		res := s.newWriter(conn)
+		if req.RequestLine.Method == "HEAD" {
+			res.OmitBody()
+		}
+		if req.RequestLine.HttpVersion == "1.0" {
+			res.SetHTTP10()
+			// HTTP/1.0 connections close unless both sides say otherwise.
+			if req.KeepAlive() {
+				res.Header().Set("Connection", "keep-alive")
+			}
+		}
+		// Headers a handler sends right away must already say whether
+		// the connection stays open.
+		res.BeforeHeaders(func(h *headers.Headers) {
+			if !s.keepAlive(req, served) {
+				h.Overwrite("Connection", "close")
+			}
+		})
+		res.OnError(func(code response.StatusCode, message string) {
+			s.renderError(res, req, code, message)
+		})
+		req.OnContinue(func() error {
+			// A handler that answered before reading gets no 100, the
+			// client may then send the body or give up on it.
+			if res.Committed() {
+				return nil
+			}
+			return res.WriteInformational(response.CONTINUE, nil)
+		})
+		// After a panic the response is not finished, so that a body cut
+		// short is not passed off as complete.
+		if !s.serveRequest(res, req) {
+			return
+		}
+		keep := s.keepAlive(req, served)
+		if err := req.BodyError(); err != nil {
+			// A handler that did not answer leaves the error for the
+			// server to report.
+			if code, ok := statusForError(err); ok && !res.Committed() {
+				s.renderError(res, req, code, errorMessage(code, err))
+			}
+		}
+		if !keep {
+			// Only has an effect while the headers are still held back.
+			res.Header().Overwrite("Connection", "close")
+		}
+		if err := res.Finish(); err != nil || !keep || !reusable(res) {
+			return
+		}
+		if err := req.DiscardBody(maxDiscardBytes); err != nil {
+			return
+		}
 	}
+}
 
+// newWriter returns a Writer for conn that adds the Date and Server headers
+// unless the handler sets them.
+func (s *Server) newWriter(conn net.Conn) *response.Writer {
 	res := response.NewWriter(conn)
+	res.BeforeHeaders(func(h *headers.Headers) {
+		if _, ok := h.Get("Date"); !ok {
+			h.Add("Date", response.Date())
+		}
+		if _, ok := h.Get("Server"); !ok && s.serverName != "" {
+			h.Add("Server", s.serverName)
+		}
+	})
+	return res
+}
+
+// serveRequest runs the handler and recovers from its panics. It returns
+// false when the connection must not be reused.
+func (s *Server) serveRequest(res *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		stack := debug.Stack()
+		s.logf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, stack)
+		if s.panicHook != nil {
+			s.panicHook(req, v, stack)
+		}
+		// A partly written response cannot be turned into an error, the
+		// client learns about the failure from the connection closing.
+		if !res.Committed() {
+			res.Header().Set("Connection", "close")
+			s.renderError(res, req, response.INTERNAL_SERVER_ERROR, "")
+		}
+		ok = false
+	}()
 	s.handler(res, req)
+	return true
+}
+
+// rejectRequest answers a request that could not be read with the matching
+// error status. Clients that went away get no response.
+func (s *Server) rejectRequest(conn net.Conn, err error) {
+	code, ok := statusForError(err)
+	if !ok {
+		if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			s.logf("Error reading request: %v", err)
+		}
+		return
+	}
+	conn.SetWriteDeadline(deadline(time.Now(), s.writeTimeout))
+	res := s.newWriter(conn)
+	res.Header().Set("Connection", "close")
+	s.renderError(res, nil, code, errorMessage(code, err))
+	res.Finish()
+}
+
+// errorMessage returns the message shown for a request error. Timeouts get
+// none, their error text only describes the connection.
+func errorMessage(code response.StatusCode, err error) string {
+	if code == response.REQUEST_TIMEOUT {
+		return ""
+	}
+	return err.Error()
+}
+
+func statusForError(err error) (response.StatusCode, bool) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, request.ErrBadRequestLine),
+		errors.Is(err, request.ErrBadMethod),
+		errors.Is(err, request.ErrBadTarget),
+		errors.Is(err, request.ErrMalformedHeader),
+		errors.Is(err, request.ErrBadContentLength),
+		errors.Is(err, request.ErrBadTransferEncoding),
+		errors.Is(err, request.ErrConflictingFraming),
+		errors.Is(err, request.ErrBadChunk),
+		errors.Is(err, request.ErrBadHost):
+		return response.BAD_REQUEST, true
+	case errors.Is(err, request.ErrMethodNotImplemented),
+		errors.Is(err, request.ErrTransferCodingNotImplemented):
+		return response.NOT_IMPLEMENTED, true
+	case errors.Is(err, request.ErrExpectationFailed):
+		return response.EXPECTATION_FAILED, true
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.HTTP_VERSION_NOT_SUPPORTED, true
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.CONTENT_TOO_LARGE, true
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.REQUEST_HEADER_FIELDS_TOO_LARGE, true
+	case errors.Is(err, request.ErrURITooLong):
+		return response.URI_TOO_LONG, true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return response.REQUEST_TIMEOUT, true
+	}
+	return 0, false
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.errorLog != nil {
+		s.errorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func deadline(from time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return from.Add(timeout)
+}
+
+func (s *Server) keepAlive(req *request.Request, served int) bool {
+	if s.closed.Load() {
+		return false
+	}
+	if s.maxRequestsPerConn > 0 && served >= s.maxRequestsPerConn {
+		return false
+	}
+	if req.AwaitingContinue() {
+		return false
+	}
+	// The rest of the body cannot be skipped to reach the next request.
+	if req.BodyError() != nil || req.BodyRemaining() > maxDiscardBytes {
+		return false
+	}
+	return req.KeepAlive()
+}
+
+// reusable reports whether the response that was sent lets the client find
+// the start of the next one.
+func reusable(res *response.Writer) bool {
+	h := res.SentHeaders()
+	if h == nil || h.HasToken("Connection", "close") {
+		return false
+	}
+	if !response.BodyAllowed(res.Status()) {
+		return true
+	}
+	// Without Content-Length or chunked framing the client can only find the
+	// end of the body by the connection closing.
+	if _, ok := h.Get("Content-Length"); ok {
+		return true
+	}
+	return h.HasToken("Transfer-Encoding", "chunked")
 }
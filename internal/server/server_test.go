@@ -0,0 +1,662 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, handler Handler, opts ...Option) *Server {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readResponse reads one Content-Length framed response and returns the
+// status line, the lower-cased headers and the body.
+func readResponse(t *testing.T, r *bufio.Reader) (string, map[string]string, string) {
+	t.Helper()
+	status, err := r.ReadString('\n')
+	require.NoError(t, err)
+	h := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		k, v, _ := strings.Cut(line, ":")
+		h[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	n, _ := strconv.Atoi(h["content-length"])
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	require.NoError(t, err)
+	return strings.TrimRight(status, "\r\n"), h, string(body)
+}
+
+func echoTarget(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Several requests share one connection
+	s := startServer(t, echoTarget)
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	for _, target := range []string{"/one", "/two", "/three"} {
+		_, err := conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		status, _, body := readResponse(t, r)
+		assert.Equal(t, "HTTP/1.1 200 OK", status)
+		assert.Equal(t, target, body)
+	}
+
+	// Test: Pipelined requests are answered in order
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /a HTTP/1.1\r\nHost: localhost\r\n\r\nGET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, _, body := readResponse(t, r)
+	assert.Equal(t, "/a", body)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/b", body)
+
+	// Test: Client Connection: close ends the connection
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET /bye HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, body := readResponse(t, r)
+	assert.Equal(t, "/bye", body)
+	assert.Equal(t, "close", h["connection"])
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestKeepAliveServerClose(t *testing.T) {
+	// Test: Handler Connection: close ends the connection
+	s := startServer(t, func(w *response.Writer, _ *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Set("Connection", "close")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	readResponse(t, r)
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Max requests per connection
+	s = startServer(t, echoTarget, WithMaxRequestsPerConn(2))
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\nGET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, _ := readResponse(t, r)
+	assert.Equal(t, "", h["connection"])
+	_, h, _ = readResponse(t, r)
+	assert.Equal(t, "close", h["connection"])
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Unread body too large to skip ends the connection
+	s = startServer(t, echoTarget)
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1000000\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, _ = readResponse(t, r)
+	assert.Equal(t, "close", h["connection"])
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRequestBody(t *testing.T) {
+	// Test: Handler reads the body before the upload finishes
+	firstPart := make(chan string)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/upload" {
+			p := make([]byte, 5)
+			io.ReadFull(req.Body, p)
+			firstPart <- string(p)
+		}
+		body, _ := io.ReadAll(req.Body)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", <-firstPart)
+	_, err = conn.Write([]byte(" world"))
+	require.NoError(t, err)
+	_, _, body := readResponse(t, r)
+	assert.Equal(t, " world", body)
+
+	// Test: Unread body is skipped before the next request
+	s = startServer(t, echoTarget)
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST /a HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhelloGET /b HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/a", body)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/b", body)
+
+	// Test: Unread chunked body is skipped as well
+	_, err = conn.Write([]byte("POST /c HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n0\r\n\r\nGET /d HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/c", body)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/d", body)
+}
+
+func TestExpectContinue(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/ignore" {
+			w.Write([]byte("ignored"))
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		w.Write(body)
+	})
+
+	// Test: 100 Continue is sent when the handler reads the body
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"))
+	require.NoError(t, err)
+	status, _, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 100 Continue", status)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	status, _, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "hello", body)
+
+	// Test: Unread body gets no 100 and the connection is closed
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST /ignore HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"))
+	require.NoError(t, err)
+	status, h, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "close", h["connection"])
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Unknown expectations get 417
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 200-ok\r\nContent-Length: 5\r\n\r\n"))
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 417 Expectation Failed", status)
+}
+
+func TestShutdown(t *testing.T) {
+	// Test: In-flight request finishes, idle connection is closed
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		echoTarget(w, req)
+	})
+	idle := dial(t, s)
+	_, err := idle.Write([]byte("GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	idleReader := bufio.NewReader(idle)
+	readResponse(t, idleReader)
+
+	busy := dial(t, s)
+	_, err = busy.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	_, err = idleReader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned before the active request finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	busyReader := bufio.NewReader(busy)
+	_, _, body := readResponse(t, busyReader)
+	assert.Equal(t, "/slow", body)
+	require.NoError(t, <-done)
+	_, err = busyReader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	// Test: Connections still active at the deadline are force-closed
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestAutomaticFraming(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	}, WithMaxRequestsPerConn(2))
+
+	// Test: Unframed bodies get a Content-Length and keep the connection
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\nGET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, body := readResponse(t, r)
+	assert.Equal(t, "/1", body)
+	assert.Equal(t, "2", h["content-length"])
+	assert.Empty(t, h["connection"])
+
+	// Test: The last response on a connection announces the close
+	_, h, body = readResponse(t, r)
+	assert.Equal(t, "/2", body)
+	assert.Equal(t, "close", h["connection"])
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestBodylessKeepAlive(t *testing.T) {
+	// Test: 204 and 304 responses keep the connection without framing
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/cached" {
+			w.WriteStatusLine(response.NOT_MODIFIED)
+		} else {
+			w.WriteStatusLine(response.NO_CONTENT)
+		}
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /gone HTTP/1.1\r\nHost: localhost\r\n\r\nGET /cached HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	status, h, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 204 No Content", status)
+	assert.Empty(t, h["content-length"])
+	status, _, _ = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", status)
+}
+
+func TestHeadRequests(t *testing.T) {
+	// Test: HEAD gets the GET headers without the body
+	s := startServer(t, echoTarget)
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("HEAD /video HTTP/1.1\r\nHost: localhost\r\n\r\nGET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	head := ""
+	for !strings.HasSuffix(head, "\r\n\r\n") {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		head += line
+	}
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head, "Content-Length: 6\r\n")
+	_, _, body := readResponse(t, r)
+	assert.Equal(t, "/next", body)
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/custom" {
+			w.Header().Set("Server", "custom")
+			w.Header().Set("Date", "Sun, 06 Nov 1994 08:49:37 GMT")
+		}
+		w.Write([]byte("ok"))
+	})
+
+	// Test: Date and Server are added
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET /custom HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, _ := readResponse(t, r)
+	assert.Equal(t, DefaultServerName, h["server"])
+	date, err := time.Parse(response.TimeFormat, h["date"])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), date, 2*time.Second)
+
+	// Test: Handler values win
+	_, h, _ = readResponse(t, r)
+	assert.Equal(t, "custom", h["server"])
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", h["date"])
+
+	// Test: Server header can be turned off
+	s = startServer(t, echoTarget, WithServerName(""))
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, _ = readResponse(t, bufio.NewReader(conn))
+	assert.NotContains(t, h, "server")
+	assert.Contains(t, h, "date")
+}
+
+func TestHTTP10(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/stream" {
+			w.Write([]byte("tick"))
+			w.Flush()
+			w.Write([]byte("tock"))
+			return
+		}
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	})
+
+	// Test: HTTP/1.0 connections close by default
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET /once HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	status, h, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/once", body)
+	assert.Equal(t, "close", h["connection"])
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: HTTP/1.0 keep-alive is honored and announced
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET /1 HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /2 HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	require.NoError(t, err)
+	_, h, body = readResponse(t, r)
+	assert.Equal(t, "/1", body)
+	assert.Equal(t, "keep-alive", h["connection"])
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "/2", body)
+
+	// Test: Streamed bodies are not chunked but delimited by the close
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET /stream HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	head, rest, _ := strings.Cut(string(raw), "\r\n\r\n")
+	assert.NotContains(t, head, "Transfer-Encoding")
+	assert.Contains(t, head+"\r\n", "Connection: close\r\n")
+	assert.Equal(t, "ticktock", rest)
+}
+
+func TestLimits(t *testing.T) {
+	s := startServer(t, echoTarget,
+		WithAddress("127.0.0.1"),
+		WithReadHeaderTimeout(100*time.Millisecond),
+		WithMaxHeaderBytes(64),
+		WithMaxBodyBytes(8),
+	)
+	host, _, err := net.SplitHostPort(s.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+
+	// Test: Incomplete header section times out with 408
+	conn := dial(t, s)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: loc"))
+	require.NoError(t, err)
+	status, h, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 408 Request Timeout", status)
+	assert.Equal(t, "close", h["connection"])
+
+	// Test: Oversized header section gets 431
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nX-Padding: " + strings.Repeat("a", 64) + "\r\n\r\n"))
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 431 Request Header Fields Too Large", status)
+
+	// Test: Oversized request line gets 414
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 414 URI Too Long", status)
+
+	// Test: Oversized body gets 413
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 9\r\n\r\n123456789"))
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+
+	// Test: Requests within the limits are served
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("POST /ok HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\n\r\n12345678"))
+	require.NoError(t, err)
+	status, _, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/ok", body)
+
+	// Test: Oversized chunked body gets 413 when the handler ignores the error
+	chunked := "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n8\r\n12345678\r\n0\r\n\r\n"
+	s = startServer(t, func(w *response.Writer, req *request.Request) {
+		io.ReadAll(req.Body)
+	}, WithMaxBodyBytes(4))
+	conn = dial(t, s)
+	_, err = conn.Write([]byte(chunked))
+	require.NoError(t, err)
+	status, h, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+	assert.Equal(t, "close", h["connection"])
+
+	// Test: Oversized chunked body returned to HandleErrors gets 413
+	s = startServer(t, HandleErrors(func(w *response.Writer, req *request.Request) error {
+		_, err := io.ReadAll(req.Body)
+		return err
+	}, RenderText, nil), WithMaxBodyBytes(4))
+	conn = dial(t, s)
+	_, err = conn.Write([]byte(chunked))
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+
+	// Test: Malformed chunked body gets 400 when the handler ignores the error
+	badChunk := "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nabc\r\n0\r\n\r\n"
+	s = startServer(t, func(w *response.Writer, req *request.Request) {
+		io.ReadAll(req.Body)
+	})
+	conn = dial(t, s)
+	_, err = conn.Write([]byte(badChunk))
+	require.NoError(t, err)
+	status, h, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	assert.Equal(t, "close", h["connection"])
+
+	// Test: Malformed chunked body returned to HandleErrors gets 400
+	s = startServer(t, HandleErrors(func(w *response.Writer, req *request.Request) error {
+		_, err := io.ReadAll(req.Body)
+		return err
+	}, RenderText, nil))
+	conn = dial(t, s)
+	_, err = conn.Write([]byte(badChunk))
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+
+	// Test: Read timeout alone also bounds the header section
+	s = startServer(t, echoTarget, WithReadTimeout(100*time.Millisecond))
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(t, err)
+	status, h, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 408 Request Timeout", status)
+	assert.Equal(t, "close", h["connection"])
+}
+
+func TestIdleTimeout(t *testing.T) {
+	// Test: Idle keep-alive connection is closed without a response
+	s := startServer(t, echoTarget, WithIdleTimeout(50*time.Millisecond))
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	readResponse(t, r)
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	var hookValue any
+	var hookStack []byte
+	hookCalled := make(chan struct{}, 2)
+	logs := &bytes.Buffer{}
+	var logsMu sync.Mutex
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/early":
+			panic("early")
+		case "/late":
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(response.GetDefaultHeaders(10))
+			w.WriteBody([]byte("part"))
+			panic("late")
+		}
+		echoTarget(w, req)
+	},
+		WithErrorLog(log.New(writerFunc(func(p []byte) (int, error) {
+			logsMu.Lock()
+			defer logsMu.Unlock()
+			return logs.Write(p)
+		}), "", 0)),
+		WithPanicHook(func(_ *request.Request, v any, stack []byte) {
+			hookValue, hookStack = v, stack
+			hookCalled <- struct{}{}
+		}),
+	)
+
+	// Test: Panic before writing gets a 500 and the server keeps running
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET /early HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	status, h, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", status)
+	assert.Equal(t, "close", h["connection"])
+	<-hookCalled
+	assert.Equal(t, "early", hookValue)
+	assert.Contains(t, string(hookStack), "server_test.go")
+	logsMu.Lock()
+	assert.Contains(t, logs.String(), "panic serving GET /early: early")
+	logsMu.Unlock()
+
+	// Test: Panic after a partial response aborts the connection
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(got), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(got), "\r\n\r\npart"))
+	<-hookCalled
+	assert.Equal(t, "late", hookValue)
+
+	// Test: Other connections are unaffected
+	conn = dial(t, s)
+	_, err = conn.Write([]byte("GET /fine HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, _, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "/fine", body)
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestMalformedRequests(t *testing.T) {
+	s := startServer(t, echoTarget)
+	tests := []struct {
+		data   string
+		status string
+	}{
+		{"GET /\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"G3T / HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"GET /bad%zz HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"GET / HTTP/1.1\r\nHost: localhost\r\nHost localhost\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: lots\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: identity\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.1 501 Not Implemented"},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", "HTTP/1.1 501 Not Implemented"},
+		{"GET / HTTP/3.0\r\n\r\n", "HTTP/1.1 505 HTTP Version Not Supported"},
+		{"GET / HTTP/2\r\n\r\n", "HTTP/1.1 505 HTTP Version Not Supported"},
+		{"GET / FOO/1.1\r\n\r\n", "HTTP/1.1 505 HTTP Version Not Supported"},
+	}
+	for _, tt := range tests {
+		// Test: Malformed request gets an explanation before the close
+		conn := dial(t, s)
+		_, err := conn.Write([]byte(tt.data))
+		require.NoError(t, err)
+		r := bufio.NewReader(conn)
+		status, h, body := readResponse(t, r)
+		assert.Equal(t, tt.status, status, tt.data)
+		assert.Equal(t, "close", h["connection"])
+		assert.Equal(t, "text/plain", h["content-type"])
+		assert.NotEmpty(t, body)
+		_, err = r.ReadByte()
+		assert.Equal(t, io.EOF, err)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type CertFile struct {
+	CertFile string
+	KeyFile  string
+}
+
+// CertStore holds the certificates served by ServeTLS. The first
+// certificate is the default for clients that send no matching SNI name.
+type CertStore struct {
+	files []CertFile
+
+	mu       sync.RWMutex
+	certs    []*tls.Certificate
+	byName   map[string]*tls.Certificate
+	modTimes []time.Time
+}
+
+func NewCertStore(files ...CertFile) (*CertStore, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no certificates given")
+	}
+	c := &CertStore{files: files}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads every certificate and key from disk again. On error the
+// previously loaded certificates stay in use.
+func (c *CertStore) Reload() error {
+	certs := make([]*tls.Certificate, 0, len(c.files))
+	byName := make(map[string]*tls.Certificate)
+	modTimes := make([]time.Time, 0, len(c.files))
+	for _, f := range c.files {
+		modTime, err := latestModTime(f)
+		if err != nil {
+			return err
+		}
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return err
+		}
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := byName[name]; !ok {
+				byName[name] = &cert
+			}
+		}
+		certs = append(certs, &cert)
+		modTimes = append(modTimes, modTime)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs = certs
+	c.byName = byName
+	c.modTimes = modTimes
+	return nil
+}
+
+func (c *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := c.byName[name]; ok {
+		return cert, nil
+	}
+	if _, rest, ok := strings.Cut(name, "."); ok {
+		if cert, ok := c.byName["*."+rest]; ok {
+			return cert, nil
+		}
+	}
+	return c.certs[0], nil
+}
+
+// Watch reloads the certificates on SIGHUP and whenever one of the files
+// changes, checking every interval. Call the returned function to stop.
+func (c *CertStore) Watch(interval time.Duration) (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer signal.Stop(hup)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+			case <-ticker.C:
+				if !c.changed() {
+					continue
+				}
+			}
+			if err := c.Reload(); err != nil {
+				log.Printf("Error reloading certificates: %v", err)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (c *CertStore) changed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i, f := range c.files {
+		modTime, err := latestModTime(f)
+		if err != nil {
+			continue
+		}
+		if !modTime.Equal(c.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func latestModTime(f CertFile) (time.Time, error) {
+	certInfo, err := os.Stat(f.CertFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(f.KeyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
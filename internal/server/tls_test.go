@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"httpserver/internal/request"
+	"httpserver/internal/response"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSigned writes a self-signed certificate for names into dir and
+// returns the file pair.
+func writeSelfSigned(t *testing.T, dir, prefix, commonName string, names ...string) CertFile {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	f := CertFile{
+		CertFile: filepath.Join(dir, prefix+".crt"),
+		KeyFile:  filepath.Join(dir, prefix+".key"),
+	}
+	require.NoError(t, os.WriteFile(f.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(f.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return f
+}
+
+func commonName(t *testing.T, store *CertStore, serverName string) string {
+	t.Helper()
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	require.NoError(t, err)
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertStoreSNI(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewCertStore(
+		writeSelfSigned(t, dir, "default", "default", "localhost"),
+		writeSelfSigned(t, dir, "api", "api", "api.example.com"),
+		writeSelfSigned(t, dir, "wildcard", "wildcard", "*.example.com"),
+	)
+	require.NoError(t, err)
+
+	// Test: Exact name match
+	assert.Equal(t, "api", commonName(t, store, "api.example.com"))
+	assert.Equal(t, "api", commonName(t, store, "API.example.com"))
+
+	// Test: Wildcard match
+	assert.Equal(t, "wildcard", commonName(t, store, "www.example.com"))
+
+	// Test: Unknown or missing SNI falls back to the first certificate
+	assert.Equal(t, "default", commonName(t, store, "other.test"))
+	assert.Equal(t, "default", commonName(t, store, ""))
+
+	// Test: No certificates
+	_, err = NewCertStore()
+	assert.Error(t, err)
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewCertStore(writeSelfSigned(t, dir, "site", "first", "localhost"))
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, store, "localhost"))
+
+	// Test: Explicit reload picks up new files
+	writeSelfSigned(t, dir, "site", "second", "localhost")
+	require.NoError(t, store.Reload())
+	assert.Equal(t, "second", commonName(t, store, "localhost"))
+
+	// Test: Failed reload keeps the old certificate
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "site.key"), []byte("garbage"), 0o600))
+	assert.Error(t, store.Reload())
+	assert.Equal(t, "second", commonName(t, store, "localhost"))
+
+	// Test: Watch reloads when the files change
+	writeSelfSigned(t, dir, "site", "second", "localhost")
+	require.NoError(t, store.Reload())
+	stop := store.Watch(10 * time.Millisecond)
+	defer stop()
+	future := time.Now().Add(time.Minute)
+	f := writeSelfSigned(t, dir, "site", "third", "localhost")
+	require.NoError(t, os.Chtimes(f.CertFile, future, future))
+	assert.Eventually(t, func() bool {
+		return commonName(t, store, "localhost") == "third"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	f := writeSelfSigned(t, dir, "localhost", "localhost", "localhost")
+	store, err := NewCertStore(f)
+	require.NoError(t, err)
+	s, err := ServeTLS(0, echoTarget, store)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	roots := x509.NewCertPool()
+	pemBytes, err := os.ReadFile(f.CertFile)
+	require.NoError(t, err)
+	require.True(t, roots.AppendCertsFromPEM(pemBytes))
+
+	// Test: Request over TLS with a verified certificate
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{ServerName: "localhost", RootCAs: roots})
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /secure HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	status, _, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "/secure", body)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverFile := writeSelfSigned(t, dir, "server", "localhost", "localhost")
+	clientFile := writeSelfSigned(t, dir, "client", "billing", "billing.internal")
+	strangerFile := writeSelfSigned(t, dir, "stranger", "stranger")
+	store, err := NewCertStore(serverFile)
+	require.NoError(t, err)
+	clientCAs, err := LoadCertPool(clientFile.CertFile)
+	require.NoError(t, err)
+	roots, err := LoadCertPool(serverFile.CertFile)
+	require.NoError(t, err)
+
+	identity := func(w *response.Writer, req *request.Request) {
+		body := []byte(req.TLS.Subject().CommonName + " " + strings.Join(req.TLS.SANs(), ","))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	authorize := ClientCertAuthorizer(map[string][]string{"billing": {"/invoices"}}, nil)
+	s, err := ServeTLS(0, authorize(identity), store, WithClientAuth(clientCAs, tls.VerifyClientCertIfGiven))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	get := func(certFile *CertFile, target string) string {
+		config := &tls.Config{ServerName: "localhost", RootCAs: roots}
+		if certFile != nil {
+			cert, err := tls.LoadX509KeyPair(certFile.CertFile, certFile.KeyFile)
+			require.NoError(t, err)
+			config.Certificates = []tls.Certificate{cert}
+		}
+		conn, err := tls.Dial("tcp", s.Addr().String(), config)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\nAccept: text/plain\r\n\r\n"))
+		require.NoError(t, err)
+		status, _, body := readResponse(t, bufio.NewReader(conn))
+		return status + "|" + body
+	}
+
+	// Test: Verified client certificate is exposed to handlers
+	assert.Equal(t, "HTTP/1.1 200 OK|billing billing.internal", get(&clientFile, "/invoices/42?full=1"))
+
+	// Test: Verified client outside its allowed prefixes
+	assert.Equal(t, "HTTP/1.1 403 Forbidden|403 Forbidden\n", get(&clientFile, "/invoicesx"))
+	assert.Equal(t, "HTTP/1.1 403 Forbidden|403 Forbidden\n", get(&clientFile, "/admin"))
+
+	// Test: Dot-segments cannot escape an allowed prefix
+	assert.Equal(t, "HTTP/1.1 403 Forbidden|403 Forbidden\n", get(&clientFile, "/invoices/../admin/secret"))
+	assert.Equal(t, "HTTP/1.1 403 Forbidden|403 Forbidden\n", get(&clientFile, "/invoices/%2e%2e/admin/secret"))
+
+	// Test: Absolute-form targets are matched on their path
+	assert.Equal(t, "HTTP/1.1 200 OK|billing billing.internal", get(&clientFile, "https://localhost/invoices/42"))
+
+	// Test: No client certificate
+	assert.Equal(t, "HTTP/1.1 403 Forbidden|403 Forbidden\n", get(nil, "/invoices"))
+
+	// Test: Certificate from an unknown CA fails the handshake
+	cert, err := tls.LoadX509KeyPair(strangerFile.CertFile, strangerFile.KeyFile)
+	require.NoError(t, err)
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+		ServerName: "localhost",
+		RootCAs:    roots,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		},
+	})
+	if err == nil {
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+	}
+	var netErr net.Error
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &netErr) && netErr.Timeout())
+}